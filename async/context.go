@@ -0,0 +1,29 @@
+package async
+
+import "context"
+
+type contextKey int
+
+const (
+	argsKey contextKey = iota
+	dataKey
+	spawnerKey
+)
+
+func withExecution(ctx context.Context, args []interface{}, data map[string]interface{}) context.Context {
+	ctx = context.WithValue(ctx, argsKey, args)
+	ctx = context.WithValue(ctx, dataKey, data)
+	return ctx
+}
+
+// Args returns the arguments of the function being executed
+func Args(ctx context.Context) []interface{} {
+	args, _ := ctx.Value(argsKey).([]interface{})
+	return args
+}
+
+// Data returns the data of the job being executed
+func Data(ctx context.Context) map[string]interface{} {
+	data, _ := ctx.Value(dataKey).(map[string]interface{})
+	return data
+}
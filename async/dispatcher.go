@@ -7,26 +7,31 @@ import (
 	"sync"
 )
 
+// Function is a function run by the worker.
+// Its context is cancelled when its job is cancelled.
 type Function func(context.Context) error
 
+// handler is the internal representation of a registered function
+type handler func(context.Context) (interface{}, error)
+
 type dispatcher struct {
 	sync.RWMutex
-	funcs map[string]Function
+	funcs map[string]handler
 }
 
 func newDispatcher() *dispatcher {
 	return &dispatcher{
-		funcs: make(map[string]Function),
+		funcs: make(map[string]handler),
 	}
 }
 
-func (d *dispatcher) addFunc(name string, fun Function) {
+func (d *dispatcher) addFunc(name string, fun handler) {
 	d.Lock()
 	defer d.Unlock()
 	d.funcs[name] = fun
 }
 
-func (d *dispatcher) getFunc(name string) Function {
+func (d *dispatcher) getFunc(name string) handler {
 	d.RLock()
 	defer d.RUnlock()
 	return d.funcs[name]
@@ -43,20 +48,21 @@ func (d *dispatcher) Capabilities() []string {
 	return caps
 }
 
-func (d *dispatcher) dispatch(ctx context.Context, funName string, args []interface{}, data map[string]interface{}) error {
+func (d *dispatcher) dispatch(ctx context.Context, funName string, args []interface{}, data map[string]interface{}) (interface{}, error) {
 	log.Printf("async: received execution request for %s", funName)
 
 	fun := d.getFunc(funName)
 	if fun == nil {
-		return fmt.Errorf("unknown function: %s", funName)
+		return nil, fmt.Errorf("unknown function: %s", funName)
 	}
 
-	if err := fun(ctx); err != nil {
+	result, err := fun(withExecution(ctx, args, data))
+	if err != nil {
 		log.Printf("async: %s failed: %v", funName, err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	return result, nil
 }
 
 func (d *dispatcher) PrintDebug() {
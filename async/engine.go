@@ -2,7 +2,9 @@ package async
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
@@ -14,6 +16,8 @@ import (
 
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -102,9 +106,16 @@ func (e *Engine) initAdvertiseAddr(advertiseAddr string) error {
 	return nil
 }
 
-func Func(name string, fun Function) { DefaultEngine.Func(name, fun) }
-func (e *Engine) Func(name string, fun Function) {
-	e.dispatcher.addFunc(name, fun)
+// Func registers fun under name, see newHandler for accepted signatures.
+// It panics if fun signature is not supported.
+func Func(name string, fun interface{}) { DefaultEngine.Func(name, fun) }
+func (e *Engine) Func(name string, fun interface{}) {
+	h, err := newHandler(fun)
+	if err != nil {
+		panic(fmt.Sprintf("async: invalid function %s: %v", name, err))
+	}
+
+	e.dispatcher.addFunc(name, h)
 }
 
 func Run() error { return DefaultEngine.Run() }
@@ -147,12 +158,54 @@ func (e *Engine) Exec(ctx context.Context, in *pbWorker.ExecRequest) (*pbWorker.
 
 	log.Printf("async: Exec: %s ", in.GetFunction())
 
-	if err := e.dispatcher.dispatch(ctx, in.GetFunction(), nil, nil); err != nil {
+	var args []interface{}
+	if len(in.GetArgs()) > 0 {
+		if err := json.Unmarshal(in.GetArgs(), &args); err != nil {
+			return nil, fmt.Errorf("invalid args: %v", err)
+		}
+	}
+
+	var data map[string]interface{}
+	if len(in.GetData()) > 0 {
+		if err := json.Unmarshal(in.GetData(), &data); err != nil {
+			return nil, fmt.Errorf("invalid data: %v", err)
+		}
+	}
+
+	e.RLock()
+	s := &spawner{jobID: in.GetJobId(), server: e.serverClient}
+	e.RUnlock()
+
+	result, err := e.dispatcher.dispatch(withSpawner(ctx, s), in.GetFunction(), args, data)
+	if err != nil {
 		log.Printf("async: failed to dispatch %s: %v", in.GetFunction(), err)
-		return nil, err
+
+		var decodeErr *decodeError
+		if errors.As(err, &decodeErr) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		var execErr *execError
+		if errors.As(err, &execErr) {
+			if err != error(execErr) {
+				// Wrapped errors keep the kind and code of the marked error, with their own message
+				execErr = &execError{err: status.Error(status.Code(execErr.err), err.Error()), kind: execErr.kind}
+			}
+			return nil, execErr.status()
+		}
+
+		// Errors of functions are told apart from the worker being unavailable by their details
+		return nil, (&execError{err: err}).status()
+	}
+
+	reply := &pbWorker.ExecReply{Children: s.waited()}
+	if result != nil {
+		if reply.Result, err = json.Marshal(result); err != nil {
+			return nil, fmt.Errorf("invalid result: %v", err)
+		}
 	}
 
-	return &pbWorker.ExecReply{}, nil
+	return reply, nil
 }
 
 func (e *Engine) Info(ctx context.Context, in *pbWorker.InfoRequest) (*pbWorker.InfoReply, error) {
@@ -0,0 +1,48 @@
+package async
+
+import (
+	pbWorker "github.com/wayt/async/pb/worker"
+	"google.golang.org/grpc/status"
+)
+
+// execError is a function error telling the server whether to retry the function
+type execError struct {
+	err  error
+	kind pbWorker.ExecError_Kind
+}
+
+func (e *execError) Error() string {
+	return e.err.Error()
+}
+
+func (e *execError) Unwrap() error {
+	return e.err
+}
+
+// status returns the error sent to the server, with its kind as details
+// The code of a gRPC status error is kept, so is its message.
+func (e *execError) status() error {
+	s, err := status.New(status.Code(e.err), status.Convert(e.err).Message()).WithDetails(&pbWorker.ExecError{Kind: e.kind})
+	if err != nil {
+		return e.err
+	}
+	return s.Err()
+}
+
+// Permanent marks err as permanent: the function won't be retried and its job is aborted.
+// It returns nil if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &execError{err: err, kind: pbWorker.ExecError_PERMANENT}
+}
+
+// Retryable marks err as retryable: the function is retried as long as its retry options allow it.
+// It returns nil if err is nil.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &execError{err: err, kind: pbWorker.ExecError_RETRYABLE}
+}
@@ -0,0 +1,69 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	pbWorker "github.com/wayt/async/pb/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestExecErrorStatus tests error kinds are sent as status details
+func TestExecErrorStatus(t *testing.T) {
+
+	testCases := []struct {
+		Err      error
+		Expected pbWorker.ExecError_Kind
+	}{
+		{Err: Permanent(errors.New("invalid")), Expected: pbWorker.ExecError_PERMANENT},
+		{Err: Retryable(errors.New("unavailable")), Expected: pbWorker.ExecError_RETRYABLE},
+	}
+
+	for _, c := range testCases {
+		s := status.Convert(c.Err.(*execError).status())
+		assert.Equal(t, s.Message(), c.Err.Error())
+
+		details := s.Details()
+		assert.Equal(t, len(details), 1)
+		assert.Equal(t, details[0].(*pbWorker.ExecError).Kind, c.Expected)
+	}
+
+	assert.Equal(t, Permanent(nil), nil)
+	assert.Equal(t, Retryable(nil), nil)
+}
+
+// TestExecFunctionErrors tests errors returned by functions are sent with details, keeping their code, even when wrapped
+func TestExecFunctionErrors(t *testing.T) {
+
+	testCases := []struct {
+		Err             error
+		ExpectedCode    codes.Code
+		ExpectedKind    pbWorker.ExecError_Kind
+		ExpectedMessage string
+	}{
+		{Err: errors.New("failed"), ExpectedCode: codes.Unknown, ExpectedKind: pbWorker.ExecError_UNSPECIFIED, ExpectedMessage: "failed"},
+		{Err: status.Error(codes.Unavailable, "downstream unavailable"), ExpectedCode: codes.Unavailable, ExpectedKind: pbWorker.ExecError_UNSPECIFIED, ExpectedMessage: "downstream unavailable"},
+		{Err: Permanent(errors.New("invalid")), ExpectedCode: codes.Unknown, ExpectedKind: pbWorker.ExecError_PERMANENT, ExpectedMessage: "invalid"},
+		{Err: fmt.Errorf("charge: %w", Permanent(errors.New("card declined"))), ExpectedCode: codes.Unknown, ExpectedKind: pbWorker.ExecError_PERMANENT, ExpectedMessage: "charge: card declined"},
+		{Err: fmt.Errorf("charge: %w", Retryable(status.Error(codes.Unavailable, "bank unavailable"))), ExpectedCode: codes.Unavailable, ExpectedKind: pbWorker.ExecError_RETRYABLE, ExpectedMessage: "charge: rpc error: code = Unavailable desc = bank unavailable"},
+	}
+
+	for _, c := range testCases {
+		e := &Engine{dispatcher: newDispatcher()}
+		e.Func("/v1/fail", func(ctx context.Context) error { return c.Err })
+
+		_, err := e.Exec(context.Background(), &pbWorker.ExecRequest{Function: "/v1/fail"})
+
+		s := status.Convert(err)
+		assert.Equal(t, s.Code(), c.ExpectedCode, c.Err.Error())
+		assert.Equal(t, s.Message(), c.ExpectedMessage, c.Err.Error())
+
+		details := s.Details()
+		assert.Equal(t, len(details), 1, c.Err.Error())
+		assert.Equal(t, details[0].(*pbWorker.ExecError).Kind, c.ExpectedKind, c.Err.Error())
+	}
+}
@@ -0,0 +1,122 @@
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	pb "github.com/wayt/async/pb/server"
+)
+
+// ErrNoJob is returned by Spawn when called outside of a function executed for a job
+var ErrNoJob = errors.New("not executing a job function")
+
+// JobFunction is a function of a job submitted by Spawn, with the fields of the job submission API
+// Durations are sent as nanoseconds, which the server accepts like duration strings.
+type JobFunction struct {
+	ID           string           `json:"id,omitempty"`
+	DependsOn    []string         `json:"depends_on,omitempty"`
+	Name         string           `json:"name"`
+	Args         []interface{}    `json:"args,omitempty"`
+	Delay        time.Duration    `json:"delay,omitempty"`
+	Timeout      time.Duration    `json:"timeout,omitempty"`
+	RetryOptions *JobRetryOptions `json:"retry_options,omitempty"`
+	Compensate   string           `json:"compensate,omitempty"`
+
+	Group          []JobFunction `json:"group,omitempty"`
+	Quorum         int           `json:"quorum,omitempty"`
+	MaxConcurrency int           `json:"max_concurrency,omitempty"`
+	Map            string        `json:"map,omitempty"`
+
+	If   string `json:"if,omitempty"`
+	Else string `json:"else,omitempty"`
+}
+
+// JobRetryOptions define the retry policy of a JobFunction
+type JobRetryOptions struct {
+	RetryLimit   int32         `json:"retry_limit"`
+	Backoff      string        `json:"backoff,omitempty"`
+	InitialDelay time.Duration `json:"initial_delay,omitempty"`
+	MaxDelay     time.Duration `json:"max_delay,omitempty"`
+	Jitter       float64       `json:"jitter,omitempty"`
+}
+
+type spawnOptions struct {
+	wait bool
+}
+
+// SpawnOption configures a job submitted by Spawn
+type SpawnOption func(*spawnOptions)
+
+// Wait makes the job of the spawning function wait for the spawned job to finish before going on.
+// The spawned job result doesn't matter, a failed child doesn't fail its parent.
+func Wait() SpawnOption {
+	return func(o *spawnOptions) { o.wait = true }
+}
+
+// spawner submits the child jobs of a function execution
+type spawner struct {
+	sync.Mutex
+
+	jobID    string
+	server   pb.ServerClient
+	children []string // Jobs the parent waits for
+}
+
+func withSpawner(ctx context.Context, s *spawner) context.Context {
+	return context.WithValue(ctx, spawnerKey, s)
+}
+
+// waited returns the jobs the parent job waits for
+func (s *spawner) waited() []string {
+	s.Lock()
+	defer s.Unlock()
+	return s.children
+}
+
+// Spawn submits a job running functions, child of the job executing the function of ctx, and returns its ID
+func Spawn(ctx context.Context, name string, functions []JobFunction, data map[string]interface{}, options ...SpawnOption) (string, error) {
+
+	s, _ := ctx.Value(spawnerKey).(*spawner)
+	if s == nil || s.jobID == "" {
+		return "", ErrNoJob
+	}
+	if s.server == nil {
+		return "", ErrCannotConnect
+	}
+
+	var o spawnOptions
+	for _, option := range options {
+		option(&o)
+	}
+
+	rawFunctions, err := json.Marshal(functions)
+	if err != nil {
+		return "", err
+	}
+
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := s.server.SubmitJob(ctx, &pb.SubmitJobRequest{
+		Name:      name,
+		Functions: rawFunctions,
+		Data:      rawData,
+		ParentId:  s.jobID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if o.wait {
+		s.Lock()
+		s.children = append(s.children, reply.JobId)
+		s.Unlock()
+	}
+
+	return reply.JobId, nil
+}
@@ -0,0 +1,106 @@
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	pb "github.com/wayt/async/pb/server"
+	"github.com/wayt/async/server/function"
+	"google.golang.org/grpc"
+)
+
+// serverClient is a pb.ServerClient recording submitted jobs
+type serverClient struct {
+	pb.ServerClient
+	submitted []*pb.SubmitJobRequest
+}
+
+func (c *serverClient) SubmitJob(ctx context.Context, in *pb.SubmitJobRequest, opts ...grpc.CallOption) (*pb.SubmitJobReply, error) {
+	c.submitted = append(c.submitted, in)
+	return &pb.SubmitJobReply{JobId: fmt.Sprintf("child-%d", len(c.submitted)), State: "queued"}, nil
+}
+
+// TestSpawn tests child jobs submission, and jobs to wait for
+func TestSpawn(t *testing.T) {
+
+	_, err := Spawn(context.Background(), "child", nil, nil)
+	assert.Equal(t, err, ErrNoJob)
+
+	client := &serverClient{}
+	s := &spawner{jobID: "parent", server: client}
+	ctx := withSpawner(context.Background(), s)
+
+	functions := []JobFunction{{Name: "/v1/sum", Args: []interface{}{1, 2}}}
+	id, err := Spawn(ctx, "child", functions, map[string]interface{}{"a": 1})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id, "child-1")
+
+	id, err = Spawn(ctx, "child", functions, nil, Wait())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, id, "child-2")
+
+	assert.Equal(t, s.waited(), []string{"child-2"})
+	assert.Equal(t, len(client.submitted), 2)
+
+	in := client.submitted[0]
+	assert.Equal(t, in.ParentId, "parent")
+	assert.Equal(t, in.Name, "child")
+	assert.Equal(t, string(in.Data), `{"a":1}`)
+
+	var submitted []JobFunction
+	assert.Equal(t, json.Unmarshal(in.Functions, &submitted), nil)
+	assert.Equal(t, submitted[0].Name, "/v1/sum")
+}
+
+// TestSpawnFunctionFields tests job functions are submitted with the fields of the job submission API
+func TestSpawnFunctionFields(t *testing.T) {
+
+	client := &serverClient{}
+	ctx := withSpawner(context.Background(), &spawner{jobID: "parent", server: client})
+
+	functions := []JobFunction{
+		{
+			ID:           "charge",
+			Name:         "/v1/charge",
+			Timeout:      time.Minute,
+			RetryOptions: &JobRetryOptions{RetryLimit: 3, Backoff: "exponential", InitialDelay: time.Second},
+			Compensate:   "/v1/refund",
+		},
+		{
+			Name:      "/v1/notify",
+			DependsOn: []string{"charge"},
+			Delay:     time.Second,
+			If:        "data.notify",
+			Group:     []JobFunction{{Name: "/v1/mail"}, {Name: "/v1/sms"}},
+			Quorum:    1,
+		},
+		{Name: "/v1/resize", Map: "data.images", MaxConcurrency: 2},
+	}
+	_, err := Spawn(ctx, "child", functions, nil)
+	assert.Equal(t, err, nil)
+
+	var submitted []*function.Function
+	assert.Equal(t, json.Unmarshal(client.submitted[0].Functions, &submitted), nil)
+	assert.Equal(t, len(submitted), 3)
+
+	assert.Equal(t, submitted[0].ID, "charge")
+	assert.Equal(t, submitted[0].Timeout, function.Duration(time.Minute))
+	assert.Equal(t, submitted[0].RetryOptions.RetryLimit, int32(3))
+	assert.Equal(t, submitted[0].RetryOptions.Backoff, "exponential")
+	assert.Equal(t, submitted[0].RetryOptions.InitialDelay, function.Duration(time.Second))
+	assert.Equal(t, submitted[0].Compensate, "/v1/refund")
+
+	assert.Equal(t, submitted[1].DependsOn, []string{"charge"})
+	assert.Equal(t, submitted[1].Delay, function.Duration(time.Second))
+	assert.Equal(t, submitted[1].If, "data.notify")
+	assert.Equal(t, len(submitted[1].Group), 2)
+	assert.Equal(t, submitted[1].Group[1].Name, "/v1/sms")
+	assert.Equal(t, submitted[1].Quorum, 1)
+
+	assert.Equal(t, submitted[2].Map, "data.images")
+	assert.Equal(t, submitted[2].MaxConcurrency, 2)
+}
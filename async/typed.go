@@ -0,0 +1,112 @@
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// decodeError is returned when function input cannot be decoded.
+// Such an error will never succeed on retry.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("cannot decode input: %v", e.err)
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+// newHandler builds a handler from a user function.
+//
+// Accepted signatures are:
+//
+//	func(context.Context) error
+//	func(context.Context) (Out, error)
+//	func(context.Context, In) error
+//	func(context.Context, In) (Out, error)
+//
+// In is decoded from the function args: the whole list if In is a slice or an array,
+// the first argument otherwise. When the function has no args, In is decoded from the job data.
+func newHandler(fun interface{}) (handler, error) {
+
+	switch f := fun.(type) {
+	case Function:
+		return func(ctx context.Context) (interface{}, error) { return nil, f(ctx) }, nil
+	case func(context.Context) error:
+		return func(ctx context.Context) (interface{}, error) { return nil, f(ctx) }, nil
+	}
+
+	v := reflect.ValueOf(fun)
+	t := v.Type()
+
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("expected a func, got %s", t)
+	}
+
+	if t.NumIn() < 1 || t.NumIn() > 2 || t.In(0) != contextType {
+		return nil, errors.New("expected a context.Context and an optional input as arguments")
+	}
+
+	if t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != errorType {
+		return nil, errors.New("expected an error and an optional output as return values")
+	}
+
+	return func(ctx context.Context) (interface{}, error) {
+
+		in := []reflect.Value{reflect.ValueOf(ctx)}
+		if t.NumIn() == 2 {
+			arg := reflect.New(t.In(1))
+			if err := decodeInput(ctx, arg.Interface()); err != nil {
+				return nil, &decodeError{err: err}
+			}
+			in = append(in, arg.Elem())
+		}
+
+		out := v.Call(in)
+
+		var err error
+		if errValue := out[len(out)-1]; !errValue.IsNil() {
+			err = errValue.Interface().(error)
+		}
+
+		if len(out) == 2 {
+			return out[0].Interface(), err
+		}
+
+		return nil, err
+	}, nil
+}
+
+// decodeInput decodes the execution args or data into dst
+func decodeInput(ctx context.Context, dst interface{}) error {
+
+	var src interface{}
+
+	args := Args(ctx)
+	switch kind := reflect.TypeOf(dst).Elem().Kind(); {
+	case kind == reflect.Slice || kind == reflect.Array:
+		src = args
+	case len(args) > 0:
+		src = args[0]
+	default:
+		src = Data(ctx)
+	}
+
+	raw, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, dst)
+}
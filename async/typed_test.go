@@ -0,0 +1,80 @@
+package async
+
+import (
+	"context"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+type typedInput struct {
+	Name string `json:"name"`
+}
+
+// TestNewHandler tests handler creation from typed functions
+func TestNewHandler(t *testing.T) {
+
+	testCases := []struct {
+		Fun      interface{}
+		Args     []interface{}
+		Data     map[string]interface{}
+		Expected interface{}
+	}{
+		{
+			Fun:      func(ctx context.Context) (string, error) { return "ok", nil },
+			Expected: "ok",
+		},
+		{
+			Fun:      func(ctx context.Context, in typedInput) (string, error) { return in.Name, nil },
+			Args:     []interface{}{map[string]interface{}{"name": "from args"}},
+			Expected: "from args",
+		},
+		{
+			Fun:      func(ctx context.Context, in *typedInput) (string, error) { return in.Name, nil },
+			Data:     map[string]interface{}{"name": "from data"},
+			Expected: "from data",
+		},
+		{
+			Fun:      func(ctx context.Context, in []int) (int, error) { return len(in), nil },
+			Args:     []interface{}{1, 2, 3},
+			Expected: 3,
+		},
+	}
+
+	for _, c := range testCases {
+		h, err := newHandler(c.Fun)
+		assert.Equal(t, err, nil)
+
+		result, err := h(withExecution(context.Background(), c.Args, c.Data))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, result, c.Expected)
+	}
+}
+
+// TestNewHandlerDecodeError tests that invalid input returns a decodeError
+func TestNewHandlerDecodeError(t *testing.T) {
+
+	h, err := newHandler(func(ctx context.Context, in typedInput) error { return nil })
+	assert.Equal(t, err, nil)
+
+	_, err = h(withExecution(context.Background(), []interface{}{"not an object"}, nil))
+	_, ok := err.(*decodeError)
+	assert.Equal(t, ok, true)
+}
+
+// TestNewHandlerInvalidSignature tests that unsupported signatures are rejected
+func TestNewHandlerInvalidSignature(t *testing.T) {
+
+	invalids := []interface{}{
+		"not a func",
+		func() error { return nil },
+		func(ctx context.Context) {},
+		func(ctx context.Context, a, b int) error { return nil },
+		func(ctx context.Context) (int, int) { return 0, 0 },
+	}
+
+	for _, fun := range invalids {
+		_, err := newHandler(fun)
+		assert.Equal(t, err != nil, true)
+	}
+}
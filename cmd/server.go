@@ -16,7 +16,11 @@ var serverCmd = &cobra.Command{
 	Short: "Runs Async server daemon",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		s := server.New()
+		s, err := server.New()
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		if err := s.Run(); err != nil {
 			log.Fatal(err)
 		}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -34,9 +35,44 @@ func init() {
 	async.Func("/v1/test-fail", func(ctx context.Context) error {
 
 		time.Sleep(1 * time.Second)
-		fmt.Println("Test fail")
+		fmt.Println("Test fail", async.Args(ctx))
 		return fmt.Errorf("Test fail")
 	})
+
+	async.Func("/v1/sum", func(ctx context.Context, numbers []float64) (float64, error) {
+
+		// Retrying won't bring numbers
+		if len(numbers) == 0 {
+			return 0, async.Permanent(errors.New("nothing to sum"))
+		}
+
+		var sum float64
+		for _, n := range numbers {
+			sum += n
+		}
+		fmt.Println("Sum", sum)
+		return sum, nil
+	})
+
+	// Sums each list in a child job, this job goes on once all of them are done
+	async.Func("/v1/sum-all", func(ctx context.Context, lists [][]float64) error {
+		for _, numbers := range lists {
+			args := make([]interface{}, len(numbers))
+			for i, n := range numbers {
+				args[i] = n
+			}
+
+			functions := []async.JobFunction{{Name: "/v1/sum", Args: args}}
+			if _, err := async.Spawn(ctx, "sum", functions, nil, async.Wait()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	async.Func("/v1/square", func(ctx context.Context, n float64) (float64, error) {
+		return n * n, nil
+	})
 }
 
 func main() {
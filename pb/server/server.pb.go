@@ -10,6 +10,10 @@ It is generated from these files:
 It has these top-level messages:
 	RegisterWorkerRequest
 	RegisterWorkerReply
+	CancelJobRequest
+	CancelJobReply
+	SubmitJobRequest
+	SubmitJobReply
 */
 package server
 
@@ -67,9 +71,113 @@ func (m *RegisterWorkerReply) GetState() string {
 	return ""
 }
 
+// Job cancelling request
+type CancelJobRequest struct {
+	JobId string `protobuf:"bytes,1,opt,name=job_id,json=jobId" json:"job_id,omitempty"`
+}
+
+func (m *CancelJobRequest) Reset()                    { *m = CancelJobRequest{} }
+func (m *CancelJobRequest) String() string            { return proto.CompactTextString(m) }
+func (*CancelJobRequest) ProtoMessage()               {}
+func (*CancelJobRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *CancelJobRequest) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+// Job cancelling reply
+type CancelJobReply struct {
+	State string `protobuf:"bytes,1,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *CancelJobReply) Reset()                    { *m = CancelJobReply{} }
+func (m *CancelJobReply) String() string            { return proto.CompactTextString(m) }
+func (*CancelJobReply) ProtoMessage()               {}
+func (*CancelJobReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *CancelJobReply) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+// Job submission request
+type SubmitJobRequest struct {
+	Name      string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Functions []byte `protobuf:"bytes,2,opt,name=functions,proto3" json:"functions,omitempty"`
+	Data      []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	ParentId  string `protobuf:"bytes,4,opt,name=parent_id,json=parentId" json:"parent_id,omitempty"`
+}
+
+func (m *SubmitJobRequest) Reset()                    { *m = SubmitJobRequest{} }
+func (m *SubmitJobRequest) String() string            { return proto.CompactTextString(m) }
+func (*SubmitJobRequest) ProtoMessage()               {}
+func (*SubmitJobRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *SubmitJobRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *SubmitJobRequest) GetFunctions() []byte {
+	if m != nil {
+		return m.Functions
+	}
+	return nil
+}
+
+func (m *SubmitJobRequest) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *SubmitJobRequest) GetParentId() string {
+	if m != nil {
+		return m.ParentId
+	}
+	return ""
+}
+
+// Job submission reply
+type SubmitJobReply struct {
+	JobId string `protobuf:"bytes,1,opt,name=job_id,json=jobId" json:"job_id,omitempty"`
+	State string `protobuf:"bytes,2,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *SubmitJobReply) Reset()                    { *m = SubmitJobReply{} }
+func (m *SubmitJobReply) String() string            { return proto.CompactTextString(m) }
+func (*SubmitJobReply) ProtoMessage()               {}
+func (*SubmitJobReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *SubmitJobReply) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+func (m *SubmitJobReply) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*RegisterWorkerRequest)(nil), "server.RegisterWorkerRequest")
 	proto.RegisterType((*RegisterWorkerReply)(nil), "server.RegisterWorkerReply")
+	proto.RegisterType((*CancelJobRequest)(nil), "server.CancelJobRequest")
+	proto.RegisterType((*CancelJobReply)(nil), "server.CancelJobReply")
+	proto.RegisterType((*SubmitJobRequest)(nil), "server.SubmitJobRequest")
+	proto.RegisterType((*SubmitJobReply)(nil), "server.SubmitJobReply")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -85,6 +193,10 @@ const _ = grpc.SupportPackageIsVersion4
 type ServerClient interface {
 	// Register a worker
 	RegisterWorker(ctx context.Context, in *RegisterWorkerRequest, opts ...grpc.CallOption) (*RegisterWorkerReply, error)
+	// Cancel a job
+	CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*CancelJobReply, error)
+	// Submit a job, child of a running job when parent_id is set
+	SubmitJob(ctx context.Context, in *SubmitJobRequest, opts ...grpc.CallOption) (*SubmitJobReply, error)
 }
 
 type serverClient struct {
@@ -104,11 +216,33 @@ func (c *serverClient) RegisterWorker(ctx context.Context, in *RegisterWorkerReq
 	return out, nil
 }
 
+func (c *serverClient) CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*CancelJobReply, error) {
+	out := new(CancelJobReply)
+	err := grpc.Invoke(ctx, "/server.Server/CancelJob", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serverClient) SubmitJob(ctx context.Context, in *SubmitJobRequest, opts ...grpc.CallOption) (*SubmitJobReply, error) {
+	out := new(SubmitJobReply)
+	err := grpc.Invoke(ctx, "/server.Server/SubmitJob", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Server service
 
 type ServerServer interface {
 	// Register a worker
 	RegisterWorker(context.Context, *RegisterWorkerRequest) (*RegisterWorkerReply, error)
+	// Cancel a job
+	CancelJob(context.Context, *CancelJobRequest) (*CancelJobReply, error)
+	// Submit a job, child of a running job when parent_id is set
+	SubmitJob(context.Context, *SubmitJobRequest) (*SubmitJobReply, error)
 }
 
 func RegisterServerServer(s *grpc.Server, srv ServerServer) {
@@ -133,6 +267,42 @@ func _Server_RegisterWorker_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Server_CancelJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerServer).CancelJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/server.Server/CancelJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerServer).CancelJob(ctx, req.(*CancelJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Server_SubmitJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerServer).SubmitJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/server.Server/SubmitJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerServer).SubmitJob(ctx, req.(*SubmitJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Server_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "server.Server",
 	HandlerType: (*ServerServer)(nil),
@@ -141,6 +311,14 @@ var _Server_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterWorker",
 			Handler:    _Server_RegisterWorker_Handler,
 		},
+		{
+			MethodName: "CancelJob",
+			Handler:    _Server_CancelJob_Handler,
+		},
+		{
+			MethodName: "SubmitJob",
+			Handler:    _Server_SubmitJob_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "server.proto",
@@ -149,14 +327,24 @@ var _Server_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("server.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 144 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x29, 0x4e, 0x2d, 0x2a,
-	0x4b, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x83, 0xf0, 0x94, 0x0c, 0xb9, 0x44,
-	0x83, 0x52, 0xd3, 0x33, 0x8b, 0x4b, 0x52, 0x8b, 0xc2, 0xf3, 0x8b, 0xb2, 0x53, 0x8b, 0x82, 0x52,
-	0x0b, 0x4b, 0x53, 0x8b, 0x4b, 0x84, 0x24, 0xb8, 0xd8, 0x13, 0x53, 0x52, 0x8a, 0x52, 0x8b, 0x8b,
-	0x25, 0x18, 0x15, 0x18, 0x35, 0x38, 0x83, 0x60, 0x5c, 0x25, 0x6d, 0x2e, 0x61, 0x74, 0x2d, 0x05,
-	0x39, 0x95, 0x42, 0x22, 0x5c, 0xac, 0xc5, 0x25, 0x89, 0x25, 0xa9, 0x50, 0xe5, 0x10, 0x8e, 0x51,
-	0x04, 0x17, 0x5b, 0x30, 0xd8, 0x26, 0x21, 0x3f, 0x2e, 0x3e, 0x54, 0x6d, 0x42, 0xb2, 0x7a, 0x50,
-	0x27, 0x61, 0x75, 0x81, 0x94, 0x34, 0x2e, 0xe9, 0x82, 0x9c, 0x4a, 0x25, 0x86, 0x24, 0x36, 0xb0,
-	0x47, 0x8c, 0x01, 0x01, 0x00, 0x00, 0xff, 0xff, 0x1d, 0x3a, 0x09, 0x15, 0xd8, 0x00, 0x00, 0x00,
+	// 298 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x52, 0xc1, 0x4a, 0xc3, 0x40,
+	0x10, 0x35, 0xb5, 0x46, 0x33, 0x94, 0x52, 0x56, 0x5b, 0x42, 0xaa, 0x50, 0xf6, 0x20, 0x15, 0xa1,
+	0xa0, 0x9e, 0xc5, 0x83, 0xa7, 0x7a, 0xf0, 0x90, 0x1e, 0x3c, 0xca, 0xa6, 0x3b, 0x4a, 0x6a, 0x9a,
+	0x8d, 0xbb, 0x1b, 0xa1, 0xbf, 0xea, 0xd7, 0x48, 0x36, 0xdd, 0x24, 0x86, 0xf4, 0xb6, 0x33, 0xef,
+	0xcd, 0xcb, 0x9b, 0x37, 0x81, 0x81, 0x42, 0xf9, 0x83, 0x72, 0x91, 0x49, 0xa1, 0x05, 0x71, 0xcb,
+	0x8a, 0xde, 0xc1, 0x38, 0xc4, 0xcf, 0x58, 0x69, 0x94, 0x6f, 0x42, 0x7e, 0xa1, 0x0c, 0xf1, 0x3b,
+	0x47, 0xa5, 0x89, 0x0f, 0xa7, 0x8c, 0x73, 0x89, 0x4a, 0xf9, 0xce, 0xcc, 0x99, 0x7b, 0xa1, 0x2d,
+	0xe9, 0x2d, 0x9c, 0xb7, 0x47, 0xb2, 0x64, 0x47, 0x2e, 0xe0, 0x44, 0x69, 0xa6, 0x71, 0x4f, 0x2f,
+	0x0b, 0x7a, 0x03, 0xa3, 0x67, 0x96, 0xae, 0x31, 0x79, 0x11, 0x91, 0x95, 0x1e, 0x83, 0xbb, 0x11,
+	0xd1, 0x7b, 0xcc, 0x2d, 0x75, 0x23, 0xa2, 0x25, 0xa7, 0xd7, 0x30, 0x6c, 0x50, 0x0f, 0x4b, 0xe6,
+	0x30, 0x5a, 0xe5, 0xd1, 0x36, 0xd6, 0x0d, 0x49, 0x02, 0xfd, 0x94, 0x6d, 0x2d, 0xd1, 0xbc, 0xc9,
+	0x25, 0x78, 0x1f, 0x79, 0xba, 0xd6, 0xb1, 0x48, 0x95, 0xdf, 0x9b, 0x39, 0xf3, 0x41, 0x58, 0x37,
+	0x8a, 0x09, 0xce, 0x34, 0xf3, 0x8f, 0x0d, 0x60, 0xde, 0x64, 0x0a, 0x5e, 0xc6, 0x24, 0xa6, 0xba,
+	0xf0, 0xd6, 0x37, 0x52, 0x67, 0x65, 0x63, 0xc9, 0xe9, 0x23, 0x0c, 0x1b, 0x9f, 0x2d, 0xec, 0x75,
+	0xef, 0x51, 0xbb, 0xee, 0x35, 0x5c, 0xdf, 0xff, 0x3a, 0xe0, 0xae, 0x4c, 0xe6, 0xe4, 0x15, 0x86,
+	0xff, 0x03, 0x24, 0x57, 0x8b, 0xfd, 0x71, 0x3a, 0x6f, 0x11, 0x4c, 0x0f, 0xc1, 0x59, 0xb2, 0xa3,
+	0x47, 0xe4, 0x09, 0xbc, 0x2a, 0x38, 0xe2, 0x5b, 0x6e, 0x3b, 0xf6, 0x60, 0xd2, 0x81, 0x54, 0x02,
+	0xd5, 0x6a, 0xb5, 0x40, 0x3b, 0xe4, 0x60, 0xd2, 0x81, 0x18, 0x81, 0xc8, 0x35, 0x3f, 0xd5, 0xc3,
+	0xdf, 0x00, 0xc3, 0xfa, 0x16, 0xa0, 0x64, 0x02, 0x00, 0x00,
 }
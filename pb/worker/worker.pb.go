@@ -12,13 +12,13 @@ It has these top-level messages:
 	InfoReply
 	ExecRequest
 	ExecReply
+	ExecError
 */
 package worker
 
 import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
-import google_protobuf "github.com/golang/protobuf/ptypes/any"
 
 import (
 	context "golang.org/x/net/context"
@@ -36,6 +36,30 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type ExecError_Kind int32
+
+const (
+	ExecError_UNSPECIFIED ExecError_Kind = 0
+	ExecError_RETRYABLE   ExecError_Kind = 1
+	ExecError_PERMANENT   ExecError_Kind = 2
+)
+
+var ExecError_Kind_name = map[int32]string{
+	0: "UNSPECIFIED",
+	1: "RETRYABLE",
+	2: "PERMANENT",
+}
+var ExecError_Kind_value = map[string]int32{
+	"UNSPECIFIED": 0,
+	"RETRYABLE":   1,
+	"PERMANENT":   2,
+}
+
+func (x ExecError_Kind) String() string {
+	return proto.EnumName(ExecError_Kind_name, int32(x))
+}
+func (ExecError_Kind) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{4, 0} }
+
 // Worker information request
 type InfoRequest struct {
 }
@@ -88,9 +112,10 @@ func (m *InfoReply) GetCapabilities() []string {
 
 // Worker execution request
 type ExecRequest struct {
-	Function string               `protobuf:"bytes,1,opt,name=function" json:"function,omitempty"`
-	Args     *google_protobuf.Any `protobuf:"bytes,2,opt,name=args" json:"args,omitempty"`
-	Data     *google_protobuf.Any `protobuf:"bytes,3,opt,name=data" json:"data,omitempty"`
+	Function string `protobuf:"bytes,1,opt,name=function" json:"function,omitempty"`
+	Args     []byte `protobuf:"bytes,2,opt,name=args,proto3" json:"args,omitempty"`
+	Data     []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	JobId    string `protobuf:"bytes,4,opt,name=job_id,json=jobId" json:"job_id,omitempty"`
 }
 
 func (m *ExecRequest) Reset()                    { *m = ExecRequest{} }
@@ -105,22 +130,31 @@ func (m *ExecRequest) GetFunction() string {
 	return ""
 }
 
-func (m *ExecRequest) GetArgs() *google_protobuf.Any {
+func (m *ExecRequest) GetArgs() []byte {
 	if m != nil {
 		return m.Args
 	}
 	return nil
 }
 
-func (m *ExecRequest) GetData() *google_protobuf.Any {
+func (m *ExecRequest) GetData() []byte {
 	if m != nil {
 		return m.Data
 	}
 	return nil
 }
 
+func (m *ExecRequest) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
 // Worker execution reply
 type ExecReply struct {
+	Result   []byte   `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	Children []string `protobuf:"bytes,2,rep,name=children" json:"children,omitempty"`
 }
 
 func (m *ExecReply) Reset()                    { *m = ExecReply{} }
@@ -128,11 +162,45 @@ func (m *ExecReply) String() string            { return proto.CompactTextString(
 func (*ExecReply) ProtoMessage()               {}
 func (*ExecReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
+func (m *ExecReply) GetResult() []byte {
+	if m != nil {
+		return m.Result
+	}
+	return nil
+}
+
+func (m *ExecReply) GetChildren() []string {
+	if m != nil {
+		return m.Children
+	}
+	return nil
+}
+
+// Worker execution error, sent as status details of every failed execution
+// Its presence tells a function error from the worker being unavailable.
+type ExecError struct {
+	Kind ExecError_Kind `protobuf:"varint,1,opt,name=kind,enum=worker.ExecError_Kind" json:"kind,omitempty"`
+}
+
+func (m *ExecError) Reset()                    { *m = ExecError{} }
+func (m *ExecError) String() string            { return proto.CompactTextString(m) }
+func (*ExecError) ProtoMessage()               {}
+func (*ExecError) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *ExecError) GetKind() ExecError_Kind {
+	if m != nil {
+		return m.Kind
+	}
+	return ExecError_UNSPECIFIED
+}
+
 func init() {
 	proto.RegisterType((*InfoRequest)(nil), "worker.InfoRequest")
 	proto.RegisterType((*InfoReply)(nil), "worker.InfoReply")
 	proto.RegisterType((*ExecRequest)(nil), "worker.ExecRequest")
 	proto.RegisterType((*ExecReply)(nil), "worker.ExecReply")
+	proto.RegisterType((*ExecError)(nil), "worker.ExecError")
+	proto.RegisterEnum("worker.ExecError_Kind", ExecError_Kind_name, ExecError_Kind_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -245,23 +313,28 @@ var _Worker_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("worker.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 280 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x50, 0xbf, 0x4f, 0x84, 0x30,
-	0x18, 0xbd, 0xe3, 0x10, 0xe5, 0x03, 0x4d, 0xac, 0x0e, 0xc8, 0x84, 0x9d, 0x98, 0x38, 0x73, 0xfe,
-	0x05, 0x0e, 0x0e, 0x6e, 0x86, 0xc5, 0xd1, 0x14, 0x28, 0xa4, 0xb1, 0x47, 0x6b, 0x01, 0x85, 0x44,
-	0xff, 0x77, 0xd3, 0x96, 0xfb, 0x35, 0x38, 0xbe, 0x97, 0xf7, 0x7d, 0xef, 0x07, 0x84, 0xdf, 0x42,
-	0x7d, 0x50, 0x95, 0x49, 0x25, 0x7a, 0x81, 0x3c, 0x8b, 0xe2, 0xbb, 0x46, 0x88, 0x86, 0xd3, 0xb5,
-	0x61, 0x8b, 0xa1, 0x5e, 0x93, 0x76, 0xb2, 0x12, 0x7c, 0x09, 0xc1, 0x4b, 0x5b, 0x8b, 0x9c, 0x7e,
-	0x0e, 0xb4, 0xeb, 0xf1, 0x0f, 0xf8, 0x16, 0x4a, 0x3e, 0xa1, 0x2b, 0x70, 0x58, 0x15, 0x2d, 0x93,
-	0x65, 0xea, 0xe7, 0x0e, 0xab, 0x50, 0x04, 0xe7, 0x5f, 0x54, 0x75, 0x4c, 0xb4, 0x91, 0x63, 0xc8,
-	0x1d, 0x44, 0xf7, 0x10, 0x6e, 0xc9, 0xf8, 0x2e, 0x89, 0x22, 0x9c, 0x53, 0x1e, 0xad, 0x92, 0x65,
-	0x7a, 0x96, 0x07, 0x5b, 0x32, 0xbe, 0xce, 0x14, 0xc2, 0x10, 0x96, 0x44, 0x92, 0x82, 0x71, 0xd6,
-	0x33, 0xda, 0x45, 0x6e, 0xb2, 0x4a, 0xfd, 0xfc, 0x84, 0xc3, 0xbf, 0x10, 0x3c, 0x8f, 0xb4, 0x9c,
-	0xc3, 0xa0, 0x18, 0x2e, 0xea, 0xa1, 0x2d, 0x7b, 0x6d, 0x68, 0x53, 0xec, 0x31, 0x4a, 0xc1, 0x25,
-	0xaa, 0xe9, 0x4c, 0x90, 0x60, 0x73, 0x9b, 0xd9, 0x86, 0xd9, 0xae, 0x61, 0xf6, 0xd4, 0x4e, 0xb9,
-	0x51, 0x68, 0x65, 0x45, 0x7a, 0x62, 0x32, 0xfd, 0xab, 0xd4, 0x0a, 0x1c, 0x80, 0x6f, 0xed, 0x25,
-	0x9f, 0x36, 0x1c, 0xbc, 0x37, 0xb3, 0x1e, 0x7a, 0x00, 0x57, 0x6f, 0x82, 0x6e, 0xb2, 0x79, 0xdc,
-	0xa3, 0xc1, 0xe2, 0xeb, 0x53, 0x52, 0xf2, 0x09, 0x2f, 0xf4, 0x85, 0x7e, 0x74, 0xb8, 0x38, 0x6a,
-	0x75, 0xb8, 0xd8, 0x7b, 0xe1, 0x45, 0xe1, 0x99, 0x38, 0x8f, 0x7f, 0x01, 0x00, 0x00, 0xff, 0xff,
-	0x34, 0x09, 0x49, 0xb9, 0xc0, 0x01, 0x00, 0x00,
+	// 368 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x92, 0xd1, 0xaf, 0xd2, 0x30,
+	0x14, 0xc6, 0xef, 0x76, 0x77, 0xa7, 0x3b, 0x1b, 0x08, 0x35, 0x92, 0x85, 0x27, 0xec, 0x13, 0xf1,
+	0x81, 0x18, 0x8c, 0xcf, 0x06, 0xb5, 0x26, 0x8b, 0x4a, 0x48, 0xc5, 0x18, 0x9f, 0x48, 0xb7, 0x15,
+	0x29, 0x94, 0x75, 0x76, 0x43, 0x21, 0xf1, 0x8f, 0x37, 0xed, 0x06, 0x17, 0xde, 0xfa, 0xfb, 0xd2,
+	0x73, 0xbe, 0xaf, 0xe7, 0x14, 0xa2, 0xbf, 0x4a, 0xef, 0xb8, 0x9e, 0x94, 0x5a, 0xd5, 0x0a, 0xf9,
+	0x0d, 0xe1, 0x0e, 0x84, 0x49, 0xb1, 0x56, 0x94, 0xff, 0x3e, 0xf0, 0xaa, 0xc6, 0xff, 0x20, 0x68,
+	0xb0, 0x94, 0x27, 0xd4, 0x05, 0x57, 0xe4, 0xb1, 0x33, 0x72, 0xc6, 0x01, 0x75, 0x45, 0x8e, 0x62,
+	0x78, 0xf2, 0x87, 0xeb, 0x4a, 0xa8, 0x22, 0x76, 0xad, 0x78, 0x46, 0xf4, 0x12, 0xa2, 0x3d, 0x3b,
+	0xae, 0x4a, 0xa6, 0x99, 0x94, 0x5c, 0xc6, 0xf7, 0x23, 0x67, 0xfc, 0x40, 0xc3, 0x3d, 0x3b, 0x2e,
+	0x5a, 0x09, 0x61, 0x88, 0x32, 0x56, 0xb2, 0x54, 0x48, 0x51, 0x0b, 0x5e, 0xc5, 0xde, 0xe8, 0x7e,
+	0x1c, 0xd0, 0x1b, 0x0d, 0x6f, 0x20, 0x24, 0x47, 0x9e, 0xb5, 0x61, 0xd0, 0x10, 0x9e, 0xae, 0x0f,
+	0x45, 0x56, 0x1b, 0xc3, 0x26, 0xc5, 0x85, 0x11, 0x02, 0x8f, 0xe9, 0x5f, 0x95, 0x0d, 0x12, 0x51,
+	0x7b, 0x36, 0x5a, 0xce, 0x6a, 0x66, 0xdd, 0x23, 0x6a, 0xcf, 0xe8, 0x05, 0xf8, 0x5b, 0x95, 0xae,
+	0x44, 0x1e, 0x7b, 0xb6, 0xc3, 0xc3, 0x56, 0xa5, 0x49, 0x8e, 0xdf, 0x41, 0xd0, 0x38, 0x99, 0x77,
+	0x0e, 0xc0, 0xd7, 0xbc, 0x3a, 0xc8, 0xda, 0xba, 0x44, 0xb4, 0x25, 0xe3, 0x9f, 0x6d, 0x84, 0xcc,
+	0x35, 0x37, 0x0f, 0x36, 0x71, 0x2f, 0x8c, 0x8b, 0xa6, 0x01, 0xd1, 0x5a, 0x69, 0xf4, 0x0a, 0xbc,
+	0x9d, 0x28, 0x9a, 0x51, 0x75, 0xa7, 0x83, 0x49, 0x3b, 0xe9, 0xcb, 0x85, 0xc9, 0x67, 0x51, 0xe4,
+	0xd4, 0xde, 0xc1, 0x6f, 0xc1, 0x33, 0x84, 0x9e, 0x41, 0xf8, 0x7d, 0xfe, 0x6d, 0x41, 0x3e, 0x24,
+	0x9f, 0x12, 0xf2, 0xb1, 0x77, 0x87, 0x3a, 0x10, 0x50, 0xb2, 0xa4, 0x3f, 0x67, 0xef, 0xbf, 0x90,
+	0x9e, 0x63, 0x70, 0x41, 0xe8, 0xd7, 0xd9, 0x9c, 0xcc, 0x97, 0x3d, 0x77, 0x2a, 0xc1, 0xff, 0x61,
+	0xbb, 0xa2, 0xd7, 0xe0, 0x99, 0x15, 0xa1, 0xe7, 0x67, 0x9b, 0xab, 0xfd, 0x0d, 0xfb, 0xb7, 0x62,
+	0x29, 0x4f, 0xf8, 0xce, 0x54, 0x98, 0x28, 0x8f, 0x15, 0x57, 0x43, 0x1e, 0xf6, 0x6f, 0x45, 0x5b,
+	0x91, 0xfa, 0xf6, 0x93, 0xbc, 0xf9, 0x3f, 0x00, 0x68, 0x8a, 0x2e, 0x18, 0x34, 0x02, 0x00, 0x00,
 }
@@ -0,0 +1,1103 @@
+// Package brokertest provides a conformance test suite for broker.Broker implementations.
+//
+// A broker implementation is validated by running the suite from its own tests:
+//
+//	func TestMyBroker(t *testing.T) {
+//		brokertest.Run(t, func(t *testing.T, options broker.Options) (broker.Broker, store.JobStore, func()) {
+//			s := store.NewMemoryStore(store.Retention{})
+//			return NewMyBroker(s, options), s, func() { s.Close() }
+//		})
+//	}
+package brokertest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/broker"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
+)
+
+const (
+	// Timeout waiting for something expected to happen
+	waitTimeout = 5 * time.Second
+
+	// Delay during which something is expected not to happen
+	quietDelay = 200 * time.Millisecond
+
+	// Lease timeout of brokers under test
+	leaseTimeout = 500 * time.Millisecond
+
+	// Function whose queue is limited to a single job
+	fullFunction = "full"
+)
+
+// options are given to every broker under test
+var options = broker.Options{
+	QueueLimits: broker.QueueLimits{
+		Functions: map[string]int{fullFunction: 1},
+	},
+	LeaseTimeout: leaseTimeout,
+}
+
+// Factory returns a new broker configured with options, the store it saves jobs into and a cleanup function.
+// The suite stops the broker and waits for its consumers to return before calling cleanup.
+type Factory func(t *testing.T, options broker.Options) (b broker.Broker, s store.JobStore, cleanup func())
+
+// Run runs the conformance suite against brokers returned by newBroker
+func Run(t *testing.T, newBroker Factory) {
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h *harness)
+	}{
+		{"Schedule", testSchedule},
+		{"ConsumeByCapability", testConsumeByCapability},
+		{"Reschedule", testReschedule},
+		{"Ordering", testOrdering},
+		{"ListAndGet", testListAndGet},
+		{"Stop", testStop},
+		{"ProcessorStop", testProcessorStop},
+		{"QueueFull", testQueueFull},
+		{"LeaseExpiry", testLeaseExpiry},
+		{"ProcessorStopRedelivery", testProcessorStopRedelivery},
+		{"LeaseExtendedByTimeout", testLeaseExtendedByTimeout},
+		{"Delay", testDelay},
+		{"DelayOrdering", testDelayOrdering},
+		{"CancelDelayed", testCancelDelayed},
+		{"Group", testGroup},
+		{"GroupQuorum", testGroupQuorum},
+		{"GroupFailure", testGroupFailure},
+		{"GroupFailureCompensate", testGroupFailureCompensate},
+		{"GroupCollectedJob", testGroupCollectedJob},
+		{"GroupDelay", testGroupDelay},
+		{"Graph", testGraph},
+		{"GraphFailure", testGraphFailure},
+		{"Condition", testCondition},
+		{"Map", testMap},
+		{"MapInvalid", testMapInvalid},
+		{"WaitChildren", testWaitChildren},
+		{"WaitChildrenCollected", testWaitChildrenCollected},
+		{"CancelQueued", testCancelQueued},
+		{"CancelRunning", testCancelRunning},
+	}
+
+	for _, test := range tests {
+		fn := test.fn
+		t.Run(test.name, func(t *testing.T) {
+			b, s, cleanup := newBroker(t, options)
+			h := &harness{t: t, broker: b, store: s}
+			defer cleanup()
+			defer h.stop()
+
+			fn(t, h)
+		})
+	}
+}
+
+// harness tracks consumers started on a broker under test
+type harness struct {
+	t      *testing.T
+	broker broker.Broker
+	store  store.JobStore
+
+	stopOnce  sync.Once
+	consumers sync.WaitGroup
+}
+
+// consume starts consuming jobs with p, returns a channel closed when Consume returns
+func (h *harness) consume(p *Processor) <-chan struct{} {
+	done := make(chan struct{})
+
+	h.consumers.Add(1)
+	go func() {
+		defer h.consumers.Done()
+		defer close(done)
+
+		if err := h.broker.Consume(p); err != nil {
+			h.t.Errorf("consume: %v", err)
+		}
+	}()
+
+	return done
+}
+
+// stop stops the broker and waits for all consumers
+func (h *harness) stop() {
+	h.stopOnce.Do(func() {
+		h.broker.Stop()
+		h.consumers.Wait()
+	})
+}
+
+func (h *harness) schedule(j *job.Job) {
+	if err := h.broker.Schedule(j); err != nil {
+		h.t.Fatalf("schedule: %v", err)
+	}
+}
+
+// waitState waits until the job saved in the store reaches state
+func (h *harness) waitState(jobID uuid.UUID, state job.State) {
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		j, err := h.store.Get(jobID)
+		if err == nil && j.State == state {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				h.t.Fatalf("job %s: %v", jobID, err)
+			}
+			h.t.Fatalf("job %s: expected state %s, got %s", jobID, state, j.State)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitGroupState waits until group function index of the current function of the saved job reaches state
+func (h *harness) waitGroupState(jobID uuid.UUID, index int, state job.State) {
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		j, err := h.store.Get(jobID)
+		if err == nil && j.GetCurrentFunction().Group[index].State == string(state) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			h.t.Fatalf("job %s: group function %d didn't reach state %s", jobID, index, state)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// NewJob returns a queued job running functions in order
+func NewJob(functions ...string) *job.Job {
+
+	j := &job.Job{
+		ID:        uuid.NewV4(),
+		Name:      fmt.Sprintf("test-%s", functions),
+		State:     job.StateQueued,
+		CreatedAt: time.Now(),
+	}
+
+	for _, name := range functions {
+		j.Functions = append(j.Functions, &function.Function{Name: name})
+	}
+
+	return j
+}
+
+// NewGroupJob returns a queued job running group functions in parallel, then functions in order
+func NewGroupJob(group []string, quorum int, functions ...string) *job.Job {
+
+	g := &function.Function{Quorum: quorum}
+	for _, name := range group {
+		g.Group = append(g.Group, &function.Function{Name: name})
+	}
+
+	j := NewJob(functions...)
+	j.Functions = append([]*function.Function{g}, j.Functions...)
+
+	return j
+}
+
+// NewGraphJob returns a queued job running functions as soon as their dependencies succeeded
+// dependencies maps a function to the functions it depends on, functions are identified by their name
+func NewGraphJob(functions []string, dependencies map[string][]string) *job.Job {
+
+	j := NewJob(functions...)
+	for _, f := range j.Functions {
+		f.ID = f.Name
+		f.DependsOn = dependencies[f.Name]
+	}
+	j.Functions = function.Graph(j.Functions)
+
+	return j
+}
+
+// Execution records a job function processed by a Processor
+type Execution struct {
+	JobID    uuid.UUID
+	Function string
+}
+
+// Processor is a broker.JobProcessor recording processed jobs.
+// Jobs succeed function after function, like a worker would process them.
+type Processor struct {
+	Capabilities []string
+	Processed    chan Execution
+
+	// When not nil, Process blocks until Hang is closed
+	Hang chan struct{}
+
+	// Functions failing, their jobs end in failed state
+	Fail []string
+
+	// Jobs spawned by functions, which their job waits for
+	Children map[string][]uuid.UUID
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewProcessor returns a Processor able to run capabilities
+func NewProcessor(capabilities ...string) *Processor {
+	return &Processor{
+		Capabilities: capabilities,
+		Processed:    make(chan Execution, 100),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+func (p *Processor) Process(j *job.Job) (bool, error) {
+
+	if err := j.SetState(job.StateRunning); err != nil {
+		return false, err
+	}
+
+	p.Processed <- Execution{JobID: j.ID, Function: j.GetCurrentFunction().Name}
+
+	if p.Hang != nil {
+		<-p.Hang
+	}
+
+	f := j.GetCurrentFunction()
+	for _, name := range p.Fail {
+		if f.Name == name {
+			return false, j.SetState(job.StateFailed)
+		}
+	}
+	f.Result = map[string]interface{}{f.Name: true}
+	j.MergeResult(f.Result)
+
+	if children := p.Children[f.Name]; len(children) > 0 {
+		for _, id := range children {
+			f.Children = append(f.Children, &function.Child{JobID: id, State: string(job.StateQueued)})
+		}
+		return true, nil
+	}
+
+	if !j.IncrCurrentFunction() {
+		return false, j.SetState(j.CompletedState())
+	}
+
+	return true, nil
+}
+
+func (p *Processor) GetCapabilities() []string { return p.Capabilities }
+func (p *Processor) Stopped() <-chan struct{}  { return p.stopCh }
+
+// Stop stops the processor, the broker should stop sending it jobs
+func (p *Processor) Stop() {
+	p.stopOnce.Do(func() { close(p.stopCh) })
+}
+
+// next returns the next execution, or fails after waitTimeout
+func (p *Processor) next(t *testing.T) Execution {
+	select {
+	case e := <-p.Processed:
+		return e
+	case <-time.After(waitTimeout):
+		t.Fatal("no job processed")
+		return Execution{}
+	}
+}
+
+// none fails if a job is processed during quietDelay
+func (p *Processor) none(t *testing.T) {
+	select {
+	case e := <-p.Processed:
+		t.Fatalf("unexpected job processed: %s", e.JobID)
+	case <-time.After(quietDelay):
+	}
+}
+
+func testSchedule(t *testing.T, h *harness) {
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	j := NewJob("a")
+	h.schedule(j)
+
+	if e := p.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testConsumeByCapability(t *testing.T, h *harness) {
+
+	pa := NewProcessor("a")
+	h.consume(pa)
+
+	j := NewJob("b")
+	h.schedule(j)
+
+	pa.none(t)
+
+	pb := NewProcessor("b")
+	h.consume(pb)
+
+	if e := pb.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	pa.none(t)
+}
+
+func testReschedule(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b")
+	h.consume(p)
+
+	j := NewJob("a", "b")
+	h.schedule(j)
+
+	for _, expected := range []string{"a", "b"} {
+		e := p.next(t)
+		if e.JobID != j.ID || e.Function != expected {
+			t.Fatalf("expected job %s function %s, got %s function %s", j.ID, expected, e.JobID, e.Function)
+		}
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testOrdering(t *testing.T, h *harness) {
+
+	var jobs []*job.Job
+	for i := 0; i < 3; i++ {
+		j := NewJob("a")
+		jobs = append(jobs, j)
+		h.schedule(j)
+	}
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	for _, j := range jobs {
+		if e := p.next(t); e.JobID != j.ID {
+			t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+		}
+	}
+}
+
+func testListAndGet(t *testing.T, h *harness) {
+
+	j := NewJob("a")
+	h.schedule(j)
+
+	found, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if found.ID != j.ID || found.State != job.StateQueued {
+		t.Fatalf("unexpected job %s in state %s", found.ID, found.State)
+	}
+
+	if _, err := h.store.Get(uuid.NewV4()); err != store.ErrJobNotFound {
+		t.Fatalf("expected %v, got %v", store.ErrJobNotFound, err)
+	}
+
+	jobs := h.store.List()
+	if len(jobs) != 1 || jobs[0].ID != j.ID {
+		t.Fatalf("unexpected jobs list: %v", jobs)
+	}
+}
+
+func testStop(t *testing.T, h *harness) {
+
+	p := NewProcessor("a")
+	done := h.consume(p)
+
+	h.stop()
+
+	select {
+	case <-done:
+	case <-time.After(waitTimeout):
+		t.Fatal("consume did not return after broker stop")
+	}
+}
+
+func testProcessorStop(t *testing.T, h *harness) {
+
+	p := NewProcessor("a")
+	done := h.consume(p)
+
+	p.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(waitTimeout):
+		t.Fatal("consume did not return after processor stop")
+	}
+
+	j := NewJob("a")
+	h.schedule(j)
+
+	p.none(t)
+
+	// Job is still available to other processors
+	other := NewProcessor("a")
+	h.consume(other)
+
+	if e := other.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+}
+
+func testQueueFull(t *testing.T, h *harness) {
+
+	h.schedule(NewJob(fullFunction))
+
+	if err := h.broker.Schedule(NewJob(fullFunction)); err != broker.ErrQueueFull {
+		t.Fatalf("expected %v, got %v", broker.ErrQueueFull, err)
+	}
+
+	// Other queues are not affected
+	h.schedule(NewJob("a"))
+}
+
+func testLeaseExpiry(t *testing.T, h *harness) {
+
+	hung := NewProcessor("a")
+	hung.Hang = make(chan struct{})
+	defer close(hung.Hang)
+	h.consume(hung)
+
+	j := NewJob("a")
+	h.schedule(j)
+
+	if e := hung.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	// Lease expires, job is delivered again
+	other := NewProcessor("a")
+	h.consume(other)
+
+	if e := other.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testProcessorStopRedelivery(t *testing.T, h *harness) {
+
+	hung := NewProcessor("a")
+	hung.Hang = make(chan struct{})
+	defer close(hung.Hang)
+	h.consume(hung)
+
+	j := NewJob("a")
+	h.schedule(j)
+
+	if e := hung.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	// Processor stops before its lease expiry, job is delivered again
+	start := time.Now()
+	hung.Stop()
+
+	other := NewProcessor("a")
+	h.consume(other)
+
+	if e := other.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	if time.Since(start) >= leaseTimeout {
+		t.Fatal("job delivered again after lease expiry, not processor stop")
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testLeaseExtendedByTimeout(t *testing.T, h *harness) {
+
+	hung := NewProcessor("a")
+	hung.Hang = make(chan struct{})
+	defer close(hung.Hang)
+	h.consume(hung)
+
+	j := NewJob("a")
+	j.Functions[0].Timeout = function.Duration(2 * leaseTimeout)
+	h.schedule(j)
+
+	if e := hung.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	// Function may still be running after the configured lease timeout
+	other := NewProcessor("a")
+	h.consume(other)
+
+	select {
+	case e := <-other.Processed:
+		t.Fatalf("job %s delivered again before its function timeout", e.JobID)
+	case <-time.After(2 * leaseTimeout):
+	}
+}
+
+func testDelay(t *testing.T, h *harness) {
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	j := NewJob("a")
+	j.Delay(2 * quietDelay)
+	h.schedule(j)
+
+	p.none(t)
+
+	if e := p.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	if time.Now().Before(*j.RunAt) {
+		t.Fatal("job processed before its run time")
+	}
+}
+
+func testDelayOrdering(t *testing.T, h *harness) {
+
+	later := NewJob("a")
+	later.Delay(4 * quietDelay)
+	h.schedule(later)
+
+	sooner := NewJob("a")
+	sooner.Delay(2 * quietDelay)
+	h.schedule(sooner)
+
+	now := NewJob("a")
+	h.schedule(now)
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	for _, j := range []*job.Job{now, sooner, later} {
+		if e := p.next(t); e.JobID != j.ID {
+			t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+		}
+	}
+}
+
+func testCancelDelayed(t *testing.T, h *harness) {
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	j := NewJob("a")
+	j.Delay(quietDelay)
+	h.schedule(j)
+
+	if err := h.broker.Cancel(j); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	time.Sleep(quietDelay)
+	p.none(t)
+	h.waitState(j.ID, job.StateCancelled)
+}
+
+// executions returns the next n executions, by function name
+func (p *Processor) executions(t *testing.T, n int) map[string]Execution {
+	executions := make(map[string]Execution, n)
+	for i := 0; i < n; i++ {
+		e := p.next(t)
+		executions[e.Function] = e
+	}
+	return executions
+}
+
+func testGroup(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c")
+	h.consume(p)
+
+	j := NewGroupJob([]string{"a", "b"}, 0, "c")
+	h.schedule(j)
+
+	// Group functions run in their own jobs
+	executions := p.executions(t, 2)
+	for _, name := range []string{"a", "b"} {
+		e, ok := executions[name]
+		if !ok {
+			t.Fatalf("group function %s not processed", name)
+		}
+		if e.JobID == j.ID {
+			t.Fatalf("group function %s processed by its parent job", name)
+		}
+	}
+
+	if e := p.next(t); e.JobID != j.ID || e.Function != "c" {
+		t.Fatalf("expected job %s function c, got %s function %s", j.ID, e.JobID, e.Function)
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	group := saved.Functions[0]
+	results, ok := group.Result.([]interface{})
+	if !ok || len(results) != 2 {
+		t.Fatalf("unexpected group result: %v", group.Result)
+	}
+	for _, g := range group.Group {
+		if g.State != string(job.StateSucceeded) || g.JobID == nil {
+			t.Fatalf("unexpected group function %s state %s", g.Name, g.State)
+		}
+	}
+	if saved.Data["a"] != true || saved.Data["b"] != true {
+		t.Fatalf("group results not merged into data: %v", saved.Data)
+	}
+}
+
+func testGroupQuorum(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c")
+	p.Fail = []string{"b"}
+	h.consume(p)
+
+	j := NewGroupJob([]string{"a", "b"}, 1, "c")
+	h.schedule(j)
+
+	executions := p.executions(t, 3)
+	if e, ok := executions["c"]; !ok || e.JobID != j.ID {
+		t.Fatalf("function c of job %s not processed after quorum", j.ID)
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testGroupFailure(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c")
+	p.Fail = []string{"b"}
+	h.consume(p)
+
+	j := NewGroupJob([]string{"a", "b"}, 0, "c")
+	h.schedule(j)
+
+	p.executions(t, 2)
+	h.waitState(j.ID, job.StateFailed)
+	p.none(t)
+}
+
+func testGroupFailureCompensate(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c", "undo")
+	p.Fail = []string{"b"}
+	h.consume(p)
+
+	j := NewJob("c")
+	j.Functions[0].Compensate = "undo"
+	j.Functions = append(j.Functions, NewGroupJob([]string{"a", "b"}, 0).Functions[0])
+	h.schedule(j)
+
+	if e := p.next(t); e.Function != "c" {
+		t.Fatalf("expected function c first, got %s", e.Function)
+	}
+
+	// Functions which ran before the group are compensated as soon as its quorum can't be reached,
+	// group functions still running are left to complete
+	executions := p.executions(t, 3)
+	if e, ok := executions["undo"]; !ok || e.JobID != j.ID {
+		t.Fatalf("compensation undo of job %s not processed after group failure", j.ID)
+	}
+
+	h.waitState(j.ID, job.StateFailed)
+	p.none(t)
+}
+
+func testGroupCollectedJob(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "c")
+	h.consume(p)
+
+	q := NewProcessor("b")
+	q.Hang = make(chan struct{})
+	h.consume(q)
+
+	j := NewGroupJob([]string{"a", "b"}, 0, "c")
+	h.schedule(j)
+
+	a := p.next(t)
+	q.next(t)
+
+	// Job of a finished group function may be collected by the store retention before the group is done
+	h.waitGroupState(j.ID, 0, job.StateSucceeded)
+	if err := h.store.Delete(a.JobID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	close(q.Hang)
+
+	if e := p.next(t); e.JobID != j.ID || e.Function != "c" {
+		t.Fatalf("expected job %s function c, got %s function %s", j.ID, e.JobID, e.Function)
+	}
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testGroupDelay(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b")
+	h.consume(p)
+
+	j := NewGroupJob([]string{"a", "b"}, 0)
+	j.Functions[0].Group[0].Delay = function.Duration(2 * quietDelay)
+	start := time.Now()
+	h.schedule(j)
+
+	if e := p.next(t); e.Function != "b" {
+		t.Fatalf("expected function b first, got %s", e.Function)
+	}
+	p.none(t)
+
+	if e := p.next(t); e.Function != "a" {
+		t.Fatalf("expected function a, got %s", e.Function)
+	}
+	if time.Since(start) < 2*quietDelay {
+		t.Fatal("group function processed before its delay")
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+}
+
+func testGraph(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c", "d")
+	h.consume(p)
+
+	j := NewGraphJob([]string{"a", "b", "c", "d"}, map[string][]string{
+		"b": {"a"},
+		"c": {"a"},
+		"d": {"b", "c"},
+	})
+	h.schedule(j)
+
+	// Functions run once their dependencies succeeded
+	if e := p.next(t); e.Function != "a" {
+		t.Fatalf("expected function a first, got %s", e.Function)
+	}
+	executions := p.executions(t, 2)
+	for _, name := range []string{"b", "c"} {
+		if _, ok := executions[name]; !ok {
+			t.Fatalf("function %s not processed after its dependency", name)
+		}
+	}
+	if e := p.next(t); e.Function != "d" {
+		t.Fatalf("expected function d last, got %s", e.Function)
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	for _, g := range saved.Functions[0].Group {
+		if g.State != string(job.StateSucceeded) {
+			t.Fatalf("unexpected function %s state %s", g.Name, g.State)
+		}
+	}
+
+	// Dependency results are given to the functions depending on them
+	d, err := h.store.Get(*saved.Functions[0].Group[3].JobID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if d.Data["b"] != true || d.Data["c"] != true {
+		t.Fatalf("dependency results not merged into data: %v", d.Data)
+	}
+}
+
+func testGraphFailure(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c")
+	p.Fail = []string{"a"}
+	h.consume(p)
+
+	j := NewGraphJob([]string{"a", "b", "c"}, map[string][]string{
+		"b": {"a"},
+		"c": {"b"},
+	})
+	h.schedule(j)
+
+	if e := p.next(t); e.Function != "a" {
+		t.Fatalf("expected function a, got %s", e.Function)
+	}
+
+	h.waitState(j.ID, job.StateFailed)
+	p.none(t)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	// Functions depending on a failed function, even indirectly, are skipped
+	for _, g := range saved.Functions[0].Group[1:] {
+		if g.State != function.StateSkipped || g.JobID != nil {
+			t.Fatalf("unexpected function %s state %s", g.Name, g.State)
+		}
+	}
+}
+
+func testCondition(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b", "c", "d")
+	h.consume(p)
+
+	j := NewJob("a", "b", "c", "d")
+	j.Functions[1].If = "result.a"
+	j.Functions[2].If = "data.missing"
+	h.schedule(j)
+
+	for _, name := range []string{"a", "b", "d"} {
+		if e := p.next(t); e.Function != name {
+			t.Fatalf("expected function %s, got %s", name, e.Function)
+		}
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+	p.none(t)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if c := saved.Functions[2]; c.State != function.StateSkipped || c.Matched == nil || *c.Matched {
+		t.Fatalf("unexpected function c state %s", c.State)
+	}
+}
+
+func testMap(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b")
+	p.Hang = make(chan struct{})
+	h.consume(p)
+
+	j := NewJob("a", "b")
+	j.Data = map[string]interface{}{"items": []interface{}{"x", "y", "z"}}
+	j.Functions[0].Map = "data.items"
+	j.Functions[0].MaxConcurrency = 2
+	h.schedule(j)
+
+	// Elements beyond max concurrency wait for a running one to complete
+	p.next(t)
+
+	running, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	spawned := 0
+	for _, g := range running.Functions[0].Group {
+		if g.JobID != nil {
+			spawned++
+		}
+	}
+	if spawned != 2 {
+		t.Fatalf("expected 2 map functions spawned, got %d", spawned)
+	}
+
+	close(p.Hang)
+
+	p.executions(t, 2)
+	if e := p.next(t); e.JobID != j.ID || e.Function != "b" {
+		t.Fatalf("expected job %s function b, got %s function %s", j.ID, e.JobID, e.Function)
+	}
+
+	h.waitState(j.ID, job.StateSucceeded)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	f := saved.Functions[0]
+	results, ok := f.Result.([]interface{})
+	if !ok || len(results) != 3 {
+		t.Fatalf("unexpected map result: %v", f.Result)
+	}
+	for i, g := range f.Group {
+		if len(g.Args) != 1 || g.Args[0] != []string{"x", "y", "z"}[i] {
+			t.Fatalf("unexpected map function %d args: %v", i, g.Args)
+		}
+	}
+}
+
+func testMapInvalid(t *testing.T, h *harness) {
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	j := NewJob("a")
+	j.Data = map[string]interface{}{"items": "x"}
+	j.Functions[0].Map = "data.items"
+	h.schedule(j)
+
+	h.waitState(j.ID, job.StateAborted)
+	p.none(t)
+}
+
+func testWaitChildren(t *testing.T, h *harness) {
+
+	j := NewJob("a", "b")
+
+	child := NewJob("c")
+	child.ParentID = &j.ID
+	h.schedule(child)
+
+	p := NewProcessor("a", "b")
+	p.Children = map[string][]uuid.UUID{"a": {child.ID}}
+	h.consume(p)
+	h.schedule(j)
+
+	// Parent waits for its child before going on
+	if e := p.next(t); e.Function != "a" {
+		t.Fatalf("expected function a, got %s", e.Function)
+	}
+	p.none(t)
+
+	c := NewProcessor("c")
+	h.consume(c)
+	c.next(t)
+
+	if e := p.next(t); e.JobID != j.ID || e.Function != "b" {
+		t.Fatalf("expected job %s function b, got %s function %s", j.ID, e.JobID, e.Function)
+	}
+	h.waitState(j.ID, job.StateSucceeded)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if c := saved.Functions[0].Children; len(c) != 1 || c[0].State != string(job.StateSucceeded) {
+		t.Fatalf("unexpected children: %v", c)
+	}
+}
+
+func testWaitChildrenCollected(t *testing.T, h *harness) {
+
+	j := NewJob("a", "b")
+
+	first := NewJob("c")
+	first.ParentID = &j.ID
+	h.schedule(first)
+
+	second := NewJob("d")
+	second.ParentID = &j.ID
+	h.schedule(second)
+
+	p := NewProcessor("a", "b")
+	p.Children = map[string][]uuid.UUID{"a": {first.ID, second.ID}}
+	h.consume(p)
+	h.schedule(j)
+	p.next(t)
+
+	c := NewProcessor("c")
+	h.consume(c)
+	c.next(t)
+
+	// Finished children may be collected by the store retention before the parent goes on
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		saved, err := h.store.Get(j.ID)
+		if err == nil && len(saved.Functions[0].Children) > 0 && saved.Functions[0].Children[0].State == string(job.StateSucceeded) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child %s not joined", first.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := h.store.Delete(first.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	d := NewProcessor("d")
+	h.consume(d)
+	d.next(t)
+
+	if e := p.next(t); e.JobID != j.ID || e.Function != "b" {
+		t.Fatalf("expected job %s function b, got %s function %s", j.ID, e.JobID, e.Function)
+	}
+	h.waitState(j.ID, job.StateSucceeded)
+
+	saved, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	for _, child := range saved.Functions[0].Children {
+		if child.State != string(job.StateSucceeded) {
+			t.Fatalf("unexpected child %s state %s", child.JobID, child.State)
+		}
+	}
+}
+
+func testCancelQueued(t *testing.T, h *harness) {
+
+	j := NewJob("a")
+	h.schedule(j)
+
+	if err := h.broker.Cancel(j); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	p := NewProcessor("a")
+	h.consume(p)
+
+	p.none(t)
+	h.waitState(j.ID, job.StateCancelled)
+
+	if err := h.broker.Cancel(j); err != job.ErrFinished {
+		t.Fatalf("expected %v, got %v", job.ErrFinished, err)
+	}
+}
+
+func testCancelRunning(t *testing.T, h *harness) {
+
+	p := NewProcessor("a", "b")
+	p.Hang = make(chan struct{})
+	h.consume(p)
+
+	j := NewJob("a", "b")
+	h.schedule(j)
+
+	if e := p.next(t); e.JobID != j.ID {
+		t.Fatalf("expected job %s, got %s", j.ID, e.JobID)
+	}
+
+	running, err := h.store.Get(j.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if err := h.broker.Cancel(running); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	// Processor completes the function, next one is not scheduled
+	close(p.Hang)
+
+	p.none(t)
+	h.waitState(j.ID, job.StateCancelled)
+}
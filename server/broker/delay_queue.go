@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"container/heap"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+)
+
+// delayedJob is a job waiting for its run time
+type delayedJob struct {
+	runAt time.Time
+	seq   uint64 // Keeps insertion order of jobs with the same run time
+	job   *job.Job
+}
+
+// delayQueue is a heap of delayed jobs, earliest run time first
+type delayQueue struct {
+	jobs []*delayedJob
+	seq  uint64
+}
+
+func (q *delayQueue) Len() int { return len(q.jobs) }
+
+func (q *delayQueue) Less(i, k int) bool {
+	if q.jobs[i].runAt.Equal(q.jobs[k].runAt) {
+		return q.jobs[i].seq < q.jobs[k].seq
+	}
+	return q.jobs[i].runAt.Before(q.jobs[k].runAt)
+}
+
+func (q *delayQueue) Swap(i, k int) { q.jobs[i], q.jobs[k] = q.jobs[k], q.jobs[i] }
+
+func (q *delayQueue) Push(x interface{}) { q.jobs = append(q.jobs, x.(*delayedJob)) }
+
+func (q *delayQueue) Pop() interface{} {
+	last := q.jobs[len(q.jobs)-1]
+	q.jobs[len(q.jobs)-1] = nil
+	q.jobs = q.jobs[:len(q.jobs)-1]
+	return last
+}
+
+// add queues j until runAt
+func (q *delayQueue) add(j *job.Job, runAt time.Time) {
+	q.seq++
+	heap.Push(q, &delayedJob{runAt: runAt, seq: q.seq, job: j})
+}
+
+// popDue removes and returns jobs whose run time is not after now
+func (q *delayQueue) popDue(now time.Time) []*job.Job {
+	var due []*job.Job
+	for len(q.jobs) > 0 && !q.jobs[0].runAt.After(now) {
+		due = append(due, heap.Pop(q).(*delayedJob).job)
+	}
+	return due
+}
+
+// remove removes the job jobID, returns false if it isn't in the queue
+func (q *delayQueue) remove(jobID uuid.UUID) bool {
+	for i, d := range q.jobs {
+		if uuid.Equal(d.job.ID, jobID) {
+			heap.Remove(q, i)
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the earliest run time, false if the queue is empty
+func (q *delayQueue) next() (time.Time, bool) {
+	if len(q.jobs) == 0 {
+		return time.Time{}, false
+	}
+	return q.jobs[0].runAt, true
+}
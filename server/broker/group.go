@@ -0,0 +1,436 @@
+package broker
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/condition"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
+)
+
+// fanOut schedules a job for each ready function of j current group, j then waits for them
+// It is called by Schedule in place of queueing j
+func fanOut(b leasingBroker, s store.JobStore, j *job.Job) error {
+
+	unlock, err := b.lockJob(j.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := j.SetState(job.StateRunning); err != nil {
+		unlock()
+		return err
+	}
+
+	// Map functions already expanded were scheduled before a restart
+	if f := j.GetCurrentFunction(); f.IsMap() && f.Group == nil {
+		if err := expand(j, f); err != nil {
+			j.Error = err.Error()
+			return abortAndUnlock(b, s, j, unlock)
+		}
+	}
+
+	return joinAndUnlock(b, s, j, unlock)
+}
+
+// abortAndUnlock schedules the compensations of j, or aborts it when there are none, and unlocks j
+func abortAndUnlock(b leasingBroker, s store.JobStore, j *job.Job, unlock func()) error {
+
+	if j.Compensate(job.StateAborted) {
+		next, err := j.Clone()
+		unlock()
+		if err != nil {
+			return err
+		}
+
+		redeliver(b, s, next)
+		return nil
+	}
+
+	if err := j.SetState(job.StateAborted); err != nil {
+		unlock()
+		return err
+	}
+
+	err := s.Save(j)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	finished(b, s, j)
+	return nil
+}
+
+// isFanOut returns true if f runs as a group of jobs, or waits for the jobs it spawned
+func isFanOut(f *function.Function) bool {
+	return f.IsGroup() || f.IsMap() || len(f.Children) > 0
+}
+
+// expand turns map function f of j into a group, from the list its expression evaluates to
+func expand(j *job.Job, f *function.Function) error {
+
+	// Map expressions are validated when the job is created
+	expr, err := condition.Parse(f.Map, function.ConditionVariables...)
+	if err != nil {
+		return fmt.Errorf("map function %s: %v", f.Name, err)
+	}
+
+	list, ok := expr.Value(j.Variables()).([]interface{})
+	if !ok {
+		return fmt.Errorf("map function %s: %s is not a list", f.Name, f.Map)
+	}
+
+	f.Expand(list)
+	return nil
+}
+
+// spawnReady schedules a job for each function of group whose dependencies succeeded, up to its max concurrency
+// Functions already having a job were scheduled before, or before a restart
+func spawnReady(b leasingBroker, s store.JobStore, j *job.Job, group *function.Function) {
+
+	byID := groupByID(group)
+
+	running := 0
+	for _, g := range group.Group {
+		if g.JobID != nil && !job.State(g.State).IsFinal() {
+			running++
+		}
+	}
+
+	for _, g := range group.Group {
+		if group.MaxConcurrency > 0 && running >= group.MaxConcurrency {
+			return
+		}
+		if g.JobID != nil || g.State == function.StateSkipped || !dependenciesSucceeded(g, byID) {
+			continue
+		}
+		running++
+
+		child := newGroupJob(j, g, byID)
+		g.JobID = &child.ID
+		g.State = string(job.StateQueued)
+
+		redeliver(b, s, child)
+	}
+}
+
+// skipBlocked marks functions of group depending on a failed or skipped function as skipped
+func skipBlocked(group *function.Function) {
+
+	byID := groupByID(group)
+
+	// Skipping a function may block the ones depending on it
+	for changed := true; changed; {
+		changed = false
+
+		for _, g := range group.Group {
+			if g.JobID != nil || g.State == function.StateSkipped {
+				continue
+			}
+
+			for _, id := range g.DependsOn {
+				if dep := byID[id]; dep.State == function.StateSkipped || hasFailed(dep) {
+					g.State = function.StateSkipped
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+// hasFailed returns true if the job of group function g ended without succeeding
+func hasFailed(g *function.Function) bool {
+	state := job.State(g.State)
+	return g.JobID != nil && state != job.StateSucceeded && state.IsFinal()
+}
+
+// groupByID returns group functions having an ID
+func groupByID(group *function.Function) map[string]*function.Function {
+	byID := make(map[string]*function.Function, len(group.Group))
+	for _, g := range group.Group {
+		if g.ID != "" {
+			byID[g.ID] = g
+		}
+	}
+	return byID
+}
+
+// dependenciesSucceeded returns true if all the functions g depends on succeeded
+func dependenciesSucceeded(g *function.Function, byID map[string]*function.Function) bool {
+	for _, id := range g.DependsOn {
+		if byID[id].State != string(job.StateSucceeded) {
+			return false
+		}
+	}
+	return true
+}
+
+// newGroupJob returns a job running group function g of parent, after the delay of g
+// Its data is parent data, merged with the results of the functions g depends on
+func newGroupJob(parent *job.Job, g *function.Function, byID map[string]*function.Function) *job.Job {
+
+	f := *g
+	f.JobID = nil
+	f.State = ""
+
+	data := make(map[string]interface{}, len(parent.Data))
+	for key, value := range parent.Data {
+		data[key] = value
+	}
+
+	for _, id := range g.DependsOn {
+		if result, ok := byID[id].Result.(map[string]interface{}); ok {
+			for key, value := range result {
+				data[key] = value
+			}
+		}
+	}
+
+	child := &job.Job{
+		ID:        uuid.NewV4(),
+		Name:      fmt.Sprintf("%s/%s", parent.Name, g.Name),
+		Functions: []*function.Function{&f},
+		Data:      data,
+		State:     job.StateQueued,
+		CreatedAt: time.Now(),
+		ParentID:  &parent.ID,
+	}
+	child.Delay(time.Duration(g.Delay))
+
+	return child
+}
+
+// finished is called when j reached a final state, its parent job may go on
+func finished(b leasingBroker, s store.JobStore, j *job.Job) {
+
+	if !j.State.IsFinal() || j.ParentID == nil {
+		return
+	}
+
+	if err := joinParent(b, s, j); err != nil {
+		log.Printf("broker: fail to join job [%s][%s] parent: %v", j.Name, j.ID, err)
+	}
+}
+
+// joinParent updates the group of child parent job, and schedules it when the group is done
+func joinParent(b leasingBroker, s store.JobStore, child *job.Job) error {
+
+	unlock, err := b.lockJob(*child.ParentID)
+	if err != nil {
+		return err
+	}
+
+	saved, err := s.Get(*child.ParentID)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	// Stored jobs may be shared with readers, the parent is updated as a copy
+	parent, err := saved.Clone()
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	// Parent is done, or its group is, late jobs and jobs it doesn't wait for are ignored
+	if parent.State != job.StateRunning || !isJoinedJob(parent.GetCurrentFunction(), child.ID) {
+		unlock()
+		return nil
+	}
+
+	return joinAndUnlock(b, s, parent, unlock)
+}
+
+// joinAndUnlock joins j current group, unlocks j and then schedules or finishes j as needed
+// j may be shared with other goroutines once unlocked, it is only read or copied while locked
+func joinAndUnlock(b leasingBroker, s store.JobStore, j *job.Job, unlock func()) error {
+
+	advance, err := join(b, s, j)
+
+	var next *job.Job
+	if err == nil && advance {
+		next, err = j.Clone()
+	}
+
+	done := j.State.IsFinal()
+	unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if next != nil {
+		redeliver(b, s, next)
+	}
+
+	if done && j.ParentID != nil {
+		return joinParent(b, s, j)
+	}
+
+	return nil
+}
+
+// isJoinedJob returns true if jobID runs a function of group f, or is a child f waits for
+func isJoinedJob(f *function.Function, jobID uuid.UUID) bool {
+	for _, g := range f.Group {
+		if g.JobID != nil && uuid.Equal(*g.JobID, jobID) {
+			return true
+		}
+	}
+	for _, c := range f.Children {
+		if uuid.Equal(c.JobID, jobID) {
+			return true
+		}
+	}
+	return false
+}
+
+// join updates j current group from its jobs, schedules its ready functions and saves j, j must be locked
+// It returns true when the group succeeded and j must be scheduled for its next function.
+// When the group quorum can't be reached anymore, j fails once the functions which ran before are compensated.
+// A current function which isn't a group joins the children it waits for instead.
+func join(b leasingBroker, s store.JobStore, j *job.Job) (bool, error) {
+
+	group := j.GetCurrentFunction()
+	if !group.IsGroup() {
+		return joinChildren(s, j)
+	}
+
+	succeeded, failed := 0, 0
+	for _, g := range group.Group {
+		if g.JobID == nil {
+			continue
+		}
+
+		// Finished group jobs were recorded before, and may have been collected since
+		if !job.State(g.State).IsFinal() {
+			joinGroupJob(s, j, g)
+		}
+
+		switch state := job.State(g.State); {
+		case state == job.StateSucceeded:
+			succeeded++
+		case state.IsFinal():
+			failed++
+		}
+	}
+
+	skipBlocked(group)
+	for _, g := range group.Group {
+		if g.State == function.StateSkipped {
+			failed++
+		}
+	}
+
+	quorum := group.GroupQuorum()
+
+	if succeeded < quorum && failed <= len(group.Group)-quorum {
+		spawnReady(b, s, j, group)
+	}
+
+	switch {
+	case succeeded >= quorum:
+		results := make([]interface{}, len(group.Group))
+		for i, g := range group.Group {
+			if g.State == string(job.StateSucceeded) {
+				results[i] = g.Result
+
+				// Map results are only aggregated, they would overwrite each other in data
+				if !group.IsMap() {
+					j.MergeResult(g.Result)
+				}
+			}
+		}
+		group.Result = results
+
+		return advance(s, j)
+
+	case failed > len(group.Group)-quorum:
+		j.Error = fmt.Sprintf("%d of %d group functions failed", failed, len(group.Group))
+		if j.Compensate(job.StateFailed) {
+			j.Delay(0)
+			return true, s.Save(j)
+		}
+		if err := j.SetState(job.StateFailed); err != nil {
+			return false, err
+		}
+		return false, s.Save(j)
+
+	default:
+		return false, s.Save(j)
+	}
+}
+
+// joinGroupJob updates group function g of j from its job, a lost job is failed
+func joinGroupJob(s store.JobStore, j *job.Job, g *function.Function) {
+
+	child, err := s.Get(*g.JobID)
+	if err != nil {
+		log.Printf("broker: group job [%s] of job [%s][%s] lost: %v", g.JobID, j.Name, j.ID, err)
+		g.State = string(job.StateFailed)
+		return
+	}
+
+	f := child.GetCurrentFunction()
+	g.RetryCount = f.RetryCount
+	g.Result = f.Result
+	g.Attempts = f.Attempts
+	g.State = string(child.State)
+}
+
+// joinChildren updates the children of j current function and saves j, j must be locked
+// It returns true when all of them are finished and j must be scheduled for its next function.
+func joinChildren(s store.JobStore, j *job.Job) (bool, error) {
+
+	f := j.GetCurrentFunction()
+
+	finished := true
+	for _, c := range f.Children {
+
+		// Finished children were recorded before, and may have been collected since
+		if job.State(c.State).IsFinal() {
+			continue
+		}
+
+		child, err := s.Get(c.JobID)
+		if err != nil {
+			log.Printf("broker: child job [%s] of job [%s][%s] lost: %v", c.JobID, j.Name, j.ID, err)
+			c.State = string(job.StateFailed)
+			continue
+		}
+
+		c.State = string(child.State)
+		if !child.State.IsFinal() {
+			finished = false
+		}
+	}
+
+	if !finished {
+		return false, s.Save(j)
+	}
+
+	return advance(s, j)
+}
+
+// advance moves j to its next function, or finishes it when there is none, and saves j
+// It returns true when j must be scheduled for its next function.
+func advance(s store.JobStore, j *job.Job) (bool, error) {
+
+	if !j.IncrCurrentFunction() {
+		if err := j.SetState(j.CompletedState()); err != nil {
+			return false, err
+		}
+		return false, s.Save(j)
+	}
+
+	// Saved before unlocking, so other joined jobs see j went on
+	j.Delay(time.Duration(j.GetCurrentFunction().Delay))
+	return true, s.Save(j)
+}
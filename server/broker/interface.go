@@ -1,16 +1,24 @@
 package broker
 
 import (
-	uuid "github.com/satori/go.uuid"
+	"errors"
+
 	"github.com/wayt/async/server/job"
 )
 
+var (
+	// ErrQueueFull is returned by Schedule when the job function queue is full
+	ErrQueueFull = errors.New("queue is full")
+)
+
 type Broker interface {
 	Consume(JobProcessor) error
 	Stop()
 	Schedule(*job.Job) error
-	List() []*job.Job
-	Get(jobID uuid.UUID) (*job.Job, error)
+
+	// Cancel cancels j and removes it from its queue, it returns job.ErrFinished if j is finished.
+	// A running job is not stopped by the broker, it is not scheduled again once processed.
+	Cancel(*job.Job) error
 }
 
 type JobProcessor interface {
@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultQueueLimit is the queue limit used when none is configured
+const DefaultQueueLimit = 200
+
+// QueueLimits defines the maximum number of queued jobs by function
+type QueueLimits struct {
+	Default   int
+	Functions map[string]int
+}
+
+// For returns the queue limit of funcName
+func (l QueueLimits) For(funcName string) int {
+	if limit, ok := l.Functions[funcName]; ok {
+		return limit
+	}
+
+	if l.Default > 0 {
+		return l.Default
+	}
+
+	return DefaultQueueLimit
+}
+
+// ParseQueueLimits parses per function limits formatted as "name=limit,name=limit"
+func ParseQueueLimits(def int, functions string) (QueueLimits, error) {
+
+	limits := QueueLimits{
+		Default:   def,
+		Functions: make(map[string]int),
+	}
+
+	for _, entry := range strings.Split(functions, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return limits, fmt.Errorf("invalid queue limit: %s", entry)
+		}
+
+		limit, err := strconv.Atoi(entry[i+1:])
+		if err != nil || limit <= 0 {
+			return limits, fmt.Errorf("invalid queue limit: %s", entry)
+		}
+
+		limits.Functions[entry[:i]] = limit
+	}
+
+	return limits, nil
+}
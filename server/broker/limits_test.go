@@ -0,0 +1,27 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/wayt/async/server/broker"
+)
+
+// TestParseQueueLimits tests queue limits parsing
+func TestParseQueueLimits(t *testing.T) {
+
+	limits, err := broker.ParseQueueLimits(10, "/v1/resize=50, /v1/mail=5")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, limits.For("/v1/resize"), 50)
+	assert.Equal(t, limits.For("/v1/mail"), 5)
+	assert.Equal(t, limits.For("/v1/other"), 10)
+
+	limits, err = broker.ParseQueueLimits(0, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, limits.For("/v1/other"), broker.DefaultQueueLimit)
+
+	for _, invalid := range []string{"/v1/resize", "/v1/resize=", "=5", "/v1/resize=-1", "/v1/resize=a"} {
+		_, err := broker.ParseQueueLimits(10, invalid)
+		assert.Equal(t, err != nil, true)
+	}
+}
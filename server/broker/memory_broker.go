@@ -1,43 +1,70 @@
 package broker
 
 import (
-	"errors"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
-	cache "github.com/patrickmn/go-cache"
 	uuid "github.com/satori/go.uuid"
 	"github.com/wayt/async/server/job"
-)
-
-var (
-	ErrJobNotFound = errors.New("job not found")
+	"github.com/wayt/async/server/store"
 )
 
 // In memory job broker
+// Jobs states are saved in a JobStore, unfinished jobs of the store are scheduled again
+// Delayed jobs wait in a time ordered queue, they are moved to their function queue once due
 type memoryBroker struct {
 	sync.Mutex
+	jobsLock  sync.Mutex // Held by lockJob
 	stop      chan struct{}
+	loops     sync.WaitGroup // Background loops, done once stopped
 	jobsQueue map[string]chan *job.Job
-	jobs      *cache.Cache
+	delayed   delayQueue
+	wakeup    chan struct{} // Signals a new delayed job
+	options   Options
+	store     store.JobStore
 }
 
-const jobQueueSize = 200
+func NewMemoryBroker(s store.JobStore, options Options) Broker {
 
-func NewMemoryBroker() Broker {
-
-	return &memoryBroker{
+	b := &memoryBroker{
 		stop:      make(chan struct{}),
 		jobsQueue: make(map[string]chan *job.Job),
-		jobs:      cache.New(5*time.Minute, 10*time.Minute),
+		wakeup:    make(chan struct{}, 1),
+		options:   options,
+		store:     s,
+	}
+
+	b.loops.Add(1)
+	go b.delayLoop()
+	b.recover()
+
+	return b
+}
+
+// recover schedules unfinished jobs of the store, in their previous scheduling order
+func (b *memoryBroker) recover() {
+
+	var jobs []*job.Job
+	for _, j := range b.store.List() {
+		if !j.State.IsFinal() {
+			jobs = append(jobs, j)
+		}
+	}
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ScheduledAt.Before(jobs[k].ScheduledAt) })
+
+	for _, j := range jobs {
+		log.Printf("broker: recovering job [%s][%s]", j.Name, j.ID)
+		redeliver(b, b.store, j)
 	}
 }
 
 func (b *memoryBroker) Consume(p JobProcessor) error {
 
+	// Not closed, consumeFunc may still be holding a job when we return
 	ch := make(chan *job.Job)
-	defer close(ch)
 
 	for _, cap := range p.GetCapabilities() {
 		go b.consumeFunc(cap, p.Stopped(), ch)
@@ -56,16 +83,17 @@ func (b *memoryBroker) Consume(p JobProcessor) error {
 }
 
 func (b *memoryBroker) process(p JobProcessor, j *job.Job) {
-	reschedule, err := p.Process(j)
-	if err != nil {
-		log.Printf("broker: job process error: %v", err)
-	}
-	if reschedule {
-		if err := b.Schedule(j); err != nil {
-			log.Printf("broker: fail to reschedule job [%s][%s]: %v", j.Name, j.ID, err)
-		}
-	}
+	process(b, b.store, p, j, b.options.leaseTimeoutFor(j.GetCurrentFunction()), b.stop)
+}
 
+// Leases are only held by the processing goroutine, which is gone with the broker
+func (b *memoryBroker) acquireLease(j *job.Job, deadline time.Time) error { return nil }
+func (b *memoryBroker) releaseLease(j *job.Job) bool                      { return true }
+
+// Jobs are only updated by this broker, a single lock is enough
+func (b *memoryBroker) lockJob(jobID uuid.UUID) (func(), error) {
+	b.jobsLock.Lock()
+	return b.jobsLock.Unlock, nil
 }
 
 func (b *memoryBroker) consumeFunc(funcName string, processorStop <-chan struct{}, ch chan *job.Job) {
@@ -85,22 +113,37 @@ func (b *memoryBroker) consumeFunc(funcName string, processorStop <-chan struct{
 				return
 			}
 
-			ch <- j
+			select {
+			case ch <- j:
+			case <-b.stop:
+				return
+			case <-processorStop:
+				// Processor is gone, give the job back
+				go func() { q <- j }()
+				return
+			}
 		}
 	}
 }
 
 func (b *memoryBroker) Stop() {
 	close(b.stop)
+	b.loops.Wait()
 }
 
 func (b *memoryBroker) queueForFunc(funcName string) chan *job.Job {
 	b.Lock()
 	defer b.Unlock()
 
+	return b.queueForFuncLocked(funcName)
+}
+
+// queueForFuncLocked is queueForFunc, b must be locked
+func (b *memoryBroker) queueForFuncLocked(funcName string) chan *job.Job {
+
 	q, ok := b.jobsQueue[funcName]
 	if !ok {
-		q = make(chan *job.Job, jobQueueSize)
+		q = make(chan *job.Job, b.options.QueueLimits.For(funcName))
 		b.jobsQueue[funcName] = q
 		// log.Printf("broker: created queue %s", funcName)
 	}
@@ -108,36 +151,101 @@ func (b *memoryBroker) queueForFunc(funcName string) chan *job.Job {
 	return q
 }
 
+// Schedule queues j, it returns ErrQueueFull instead of blocking when the queue is full
+// Delayed jobs are not counted in queue limits until they are due
 func (b *memoryBroker) Schedule(j *job.Job) error {
 
-	b.jobs.Add(j.ID.String(), j, cache.DefaultExpiration)
+	if isFanOut(j.GetCurrentFunction()) && !j.IsDelayed() {
+		return fanOut(b, b.store, j)
+	}
+
+	// Consumers don't lock the broker, holding the lock guarantees the queue
+	// won't be filled between the check and the send
+	b.Lock()
+	defer b.Unlock()
+
+	delayed := j.IsDelayed()
 
-	funcName := j.GetCurrentFunction().Name
+	q := b.queueForFuncLocked(j.GetCurrentFunction().Name)
+	if !delayed && len(q) >= cap(q) {
+		return ErrQueueFull
+	}
+
+	if err := j.SetState(job.StateQueued); err != nil {
+		return err
+	}
 
 	j.ScheduledAt = time.Now()
-	q := b.queueForFunc(funcName)
+	if err := b.store.Save(j); err != nil {
+		return err
+	}
+
+	if delayed {
+		b.delayed.add(j, *j.RunAt)
+
+		select {
+		case b.wakeup <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+
 	q <- j
 
 	return nil
 }
 
-func (b *memoryBroker) List() []*job.Job {
+// delayLoop schedules delayed jobs when they are due
+func (b *memoryBroker) delayLoop() {
+	defer b.loops.Done()
 
-	jobs := make([]*job.Job, 0, b.jobs.ItemCount())
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
-	for _, item := range b.jobs.Items() {
-		jobs = append(jobs, item.Object.(*job.Job))
-	}
+	for {
+		b.Lock()
+		due := b.delayed.popDue(time.Now())
+		next, ok := b.delayed.next()
+		b.Unlock()
+
+		for _, j := range due {
+			redeliver(b, b.store, j)
+		}
 
-	return jobs
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		var timeout <-chan time.Time
+		if ok {
+			timer.Reset(time.Until(next))
+			timeout = timer.C
+		}
+
+		select {
+		case <-b.stop:
+			return
+		case <-b.wakeup:
+		case <-timeout:
+		}
+	}
 }
 
-func (b *memoryBroker) Get(jobID uuid.UUID) (*job.Job, error) {
+// Cancel cancels j, delayed jobs are removed, queued ones are dropped when dequeued
+func (b *memoryBroker) Cancel(j *job.Job) error {
+	b.Lock()
 
-	obj, ok := b.jobs.Get(jobID.String())
-	if !ok {
-		return nil, ErrJobNotFound
+	if err := cancel(b.store, j); err != nil {
+		b.Unlock()
+		return err
 	}
 
-	return obj.(*job.Job), nil
+	b.delayed.remove(j.ID)
+	b.Unlock()
+
+	finished(b, b.store, j)
+	return nil
 }
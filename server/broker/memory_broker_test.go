@@ -0,0 +1,17 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/wayt/async/server/broker"
+	"github.com/wayt/async/server/broker/brokertest"
+	"github.com/wayt/async/server/store"
+)
+
+// TestMemoryBroker runs the conformance suite against the memory broker
+func TestMemoryBroker(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T, options broker.Options) (broker.Broker, store.JobStore, func()) {
+		s := store.NewMemoryStore(store.Retention{})
+		return broker.NewMemoryBroker(s, options), s, func() { s.Close() }
+	})
+}
@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/wayt/async/server/function"
+)
+
+const (
+	// DefaultLeaseTimeout is the lease timeout used when none is configured
+	DefaultLeaseTimeout = 5 * time.Minute
+
+	// leaseTimeoutMargin is left to a processor after its function timeout, to report the failure
+	leaseTimeoutMargin = 10 * time.Second
+)
+
+// Options configures a broker
+type Options struct {
+	QueueLimits QueueLimits
+
+	// LeaseTimeout is how long a processor owns a job, the job is delivered again
+	// if the processor didn't complete it meanwhile.
+	// It is extended for functions with a longer timeout.
+	LeaseTimeout time.Duration
+}
+
+func (o Options) leaseTimeout() time.Duration {
+	if o.LeaseTimeout > 0 {
+		return o.LeaseTimeout
+	}
+
+	return DefaultLeaseTimeout
+}
+
+// leaseTimeoutFor returns the lease timeout of a job running f, so f times out before its lease expires
+func (o Options) leaseTimeoutFor(f *function.Function) time.Duration {
+
+	lease := o.leaseTimeout()
+	if timeout := time.Duration(f.Timeout); timeout > 0 && timeout+leaseTimeoutMargin > lease {
+		lease = timeout + leaseTimeoutMargin
+	}
+
+	return lease
+}
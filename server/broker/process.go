@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
+)
+
+const scheduleRetryInterval = 1 * time.Second
+
+// leasingBroker is a Broker tracking jobs owned by processors
+type leasingBroker interface {
+	Broker
+
+	// acquireLease marks j as owned until deadline
+	acquireLease(j *job.Job, deadline time.Time) error
+
+	// releaseLease ends j ownership, it returns false if the lease was already released
+	releaseLease(j *job.Job) bool
+
+	// lockJob prevents concurrent updates of a job by the broker, until unlock is called
+	lockJob(jobID uuid.UUID) (unlock func(), err error)
+}
+
+type processResult struct {
+	reschedule bool
+	err        error
+}
+
+// process hands j to p for at most leaseTimeout.
+// The processor works on a copy of j: if it doesn't complete in time or if it stops,
+// j is delivered again and the processor result is ignored.
+// j may already be leased when it was dequeued, the lease is taken again before j is saved running,
+// so a running job always has a lease.
+// When the lease expires, it returns a channel receiving the result p is still working on.
+func process(b leasingBroker, s store.JobStore, p JobProcessor, j *job.Job, leaseTimeout time.Duration, stop <-chan struct{}) <-chan processResult {
+
+	// Queued jobs may be cancelled after being sent to a consumer
+	if isCancelled(s, j) {
+		log.Printf("broker: skipping cancelled job [%s][%s]", j.Name, j.ID)
+		b.releaseLease(j)
+		return nil
+	}
+
+	if err := b.acquireLease(j, time.Now().Add(leaseTimeout)); err != nil {
+		log.Printf("broker: cannot lease job [%s][%s]: %v", j.Name, j.ID, err)
+		if b.releaseLease(j) {
+			redeliver(b, s, j)
+		}
+		return nil
+	}
+
+	if err := j.SetState(job.StateRunning); err != nil {
+		log.Printf("broker: cannot process job [%s][%s]: %v", j.Name, j.ID, err)
+		b.releaseLease(j)
+		return nil
+	}
+
+	if err := s.Save(j); err != nil {
+		log.Printf("broker: fail to save job [%s][%s]: %v", j.Name, j.ID, err)
+	}
+
+	clone, err := j.Clone()
+	if err != nil {
+		log.Printf("broker: cannot copy job [%s][%s]: %v", j.Name, j.ID, err)
+		if b.releaseLease(j) {
+			complete(b, s, j, false, err)
+		}
+		return nil
+	}
+
+	done := make(chan processResult, 1)
+	go func() {
+		reschedule, err := p.Process(clone)
+		done <- processResult{reschedule: reschedule, err: err}
+	}()
+
+	timer := time.NewTimer(leaseTimeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		if !b.releaseLease(j) {
+			log.Printf("broker: lease of job [%s][%s] lost, ignoring result", j.Name, j.ID)
+			return nil
+		}
+		complete(b, s, clone, r.reschedule, r.err)
+	case <-timer.C:
+		log.Printf("broker: lease of job [%s][%s] expired", j.Name, j.ID)
+		if b.releaseLease(j) {
+			redeliver(b, s, j)
+		}
+		return done
+	case <-p.Stopped():
+		log.Printf("broker: processor stopped while processing job [%s][%s]", j.Name, j.ID)
+		if b.releaseLease(j) {
+			redeliver(b, s, j)
+		}
+	case <-stop:
+		// Broker is stopping, unfinished jobs are recovered from the store
+	}
+
+	return nil
+}
+
+// complete reschedules j on b or saves its final state in s
+func complete(b leasingBroker, s store.JobStore, j *job.Job, reschedule bool, err error) {
+
+	// Job cancelled while processed, it won't go further
+	if j.State != job.StateCancelled && isCancelled(s, j) {
+		if j.State.IsFinal() {
+			log.Printf("broker: job [%s][%s] cancelled, ignoring %s state", j.Name, j.ID, j.State)
+			return
+		}
+
+		if err := cancel(s, j); err != nil {
+			log.Printf("broker: fail to cancel job [%s][%s]: %v", j.Name, j.ID, err)
+		}
+		finished(b, s, j)
+		return
+	}
+
+	if err != nil {
+		log.Printf("broker: job process error: %v", err)
+
+		if !j.State.IsFinal() {
+			if err := j.SetState(job.StateFailed); err != nil {
+				log.Printf("broker: %v", err)
+			}
+		}
+	}
+	if reschedule {
+		redeliver(b, s, j)
+		return
+	}
+
+	if err := s.Save(j); err != nil {
+		log.Printf("broker: fail to save job [%s][%s]: %v", j.Name, j.ID, err)
+	}
+
+	finished(b, s, j)
+}
+
+// redeliver schedules j again on b, without waiting for its queue to have room
+func redeliver(b Broker, s store.JobStore, j *job.Job) {
+
+	if isCancelled(s, j) {
+		return
+	}
+
+	switch err := b.Schedule(j); err {
+	case nil:
+	case ErrQueueFull:
+		// Waiting here could prevent the queue from being consumed
+		go retrySchedule(b, s, j)
+	default:
+		log.Printf("broker: fail to reschedule job [%s][%s]: %v", j.Name, j.ID, err)
+	}
+}
+
+// retrySchedule schedules j on b as soon as its queue has room
+func retrySchedule(b Broker, s store.JobStore, j *job.Job) {
+
+	tk := time.NewTicker(scheduleRetryInterval)
+	defer tk.Stop()
+
+	for range tk.C {
+		if isCancelled(s, j) {
+			return
+		}
+
+		switch err := b.Schedule(j); err {
+		case nil:
+			return
+		case ErrQueueFull:
+		default:
+			log.Printf("broker: fail to reschedule job [%s][%s]: %v", j.Name, j.ID, err)
+			return
+		}
+	}
+}
+
+// cancel sets j state to cancelled and saves it in s
+func cancel(s store.JobStore, j *job.Job) error {
+
+	if j.State.IsFinal() {
+		return job.ErrFinished
+	}
+
+	if err := j.SetState(job.StateCancelled); err != nil {
+		return err
+	}
+
+	return s.Save(j)
+}
+
+// isCancelled returns true if j is cancelled in s, j may be an outdated copy
+func isCancelled(s store.JobStore, j *job.Job) bool {
+
+	if j.State == job.StateCancelled {
+		return true
+	}
+
+	saved, err := s.Get(j.ID)
+	return err == nil && saved.State == job.StateCancelled
+}
@@ -0,0 +1,413 @@
+package broker
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
+)
+
+const (
+	redisQueueKeyPrefix = "async:queue:"         // List of job IDs, by function name
+	redisLeasesKey      = "async:leases"         // Sorted set of leased job IDs, by lease deadline
+	redisDelayedKey     = "async:delayed"        // Sorted set of delayed job IDs, by run time
+	redisLockKeyPrefix  = "async:lock:"          // Job locks, by job ID
+	redisLockTimeout    = 10 * time.Second       // Lock expiry, in case its owner crashed
+	redisLockRetry      = 10 * time.Millisecond  // Lock acquisition retry interval
+	redisPopInterval    = 100 * time.Millisecond // Empty queues check interval
+	redisRetryInterval  = 1 * time.Second        // Wait after an error, or without capabilities
+	redisReapInterval   = 1 * time.Second        // Expired leases check interval
+	redisDelayInterval  = 250 * time.Millisecond // Due delayed jobs check interval
+)
+
+// Redis backed job broker, queues can be shared by several servers
+// Jobs must be stored in a JobStore shared by those servers too
+// Leases are stored in redis, so jobs owned by a crashed server are delivered again by the others
+// Jobs are popped from their queue and leased at once, a server crashing in between doesn't lose them
+// Delayed jobs are stored in redis too, the first server to find them due queues them
+type redisBroker struct {
+	stop    chan struct{}
+	loops   sync.WaitGroup // Background loops, done once stopped
+	pool    *redis.Pool
+	options Options
+	store   store.JobStore
+}
+
+func NewRedisBroker(pool *redis.Pool, s store.JobStore, options Options) Broker {
+
+	b := &redisBroker{
+		stop:    make(chan struct{}),
+		pool:    pool,
+		options: options,
+		store:   s,
+	}
+
+	b.loops.Add(2)
+	go b.reapLoop()
+	go b.delayLoop()
+
+	return b
+}
+
+func redisQueueKey(funcName string) string {
+	return redisQueueKeyPrefix + funcName
+}
+
+func (b *redisBroker) Consume(p JobProcessor) error {
+
+	// Rotate queues order, so a busy queue doesn't starve others
+	for i := 0; ; i++ {
+		select {
+		case <-b.stop:
+			return nil
+		case <-p.Stopped():
+			return nil
+		default:
+		}
+
+		caps := p.GetCapabilities()
+		if len(caps) == 0 {
+			time.Sleep(redisRetryInterval)
+			continue
+		}
+
+		queues := make([]string, 0, len(caps))
+		for k := range caps {
+			queues = append(queues, redisQueueKey(caps[(i+k)%len(caps)]))
+		}
+
+		j, err := b.pop(queues)
+		if err != nil {
+			log.Printf("broker: fail to pop job: %v", err)
+			time.Sleep(redisRetryInterval)
+			continue
+		}
+		if j == nil {
+			time.Sleep(redisPopInterval)
+			continue
+		}
+
+		busy := process(b, b.store, p, j, b.options.leaseTimeoutFor(j.GetCurrentFunction()), b.stop)
+
+		// Jobs are polled, a processor still busy with an expired job would take it back before the others
+		if busy != nil {
+			select {
+			case <-busy:
+			case <-p.Stopped():
+			case <-b.stop:
+			}
+		}
+	}
+}
+
+// redisPopScript moves a job ID from the first non empty queue of KEYS to the leases set, the last key,
+// with the lease deadline ARGV[1]
+var redisPopScript = redis.NewScript(-1, `
+for i = 1, #KEYS - 1 do
+	local jobID = redis.call("RPOP", KEYS[i])
+	if jobID then
+		redis.call("ZADD", KEYS[#KEYS], ARGV[1], jobID)
+		return jobID
+	end
+end
+return false`)
+
+// pop leases the first job of queues until the broker lease timeout, returns nil if they are empty
+// The processor extends the lease according to the job function timeout.
+func (b *redisBroker) pop(queues []string) (*job.Job, error) {
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(queues)+3)
+	args = append(args, len(queues)+1)
+	for _, queue := range queues {
+		args = append(args, queue)
+	}
+	args = append(args, redisLeasesKey, redisTime(time.Now().Add(b.options.leaseTimeout())))
+
+	reply, err := redis.String(redisPopScript.Do(conn, args...))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, err := uuid.FromString(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	// A job missing from the store is left to the reaper, which logs it once its lease expires
+	return b.store.Get(jobID)
+}
+
+func (b *redisBroker) Stop() {
+	close(b.stop)
+	b.loops.Wait()
+}
+
+// Schedule queues j, it returns ErrQueueFull when the queue is full
+// Queues are shared, so the limit is a best effort: concurrent schedules may exceed it
+// Delayed jobs are not counted in queue limits until they are due
+func (b *redisBroker) Schedule(j *job.Job) error {
+
+	if isFanOut(j.GetCurrentFunction()) && !j.IsDelayed() {
+		return fanOut(b, b.store, j)
+	}
+
+	funcName := j.GetCurrentFunction().Name
+	delayed := j.IsDelayed()
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	if !delayed {
+		size, err := redis.Int(conn.Do("LLEN", redisQueueKey(funcName)))
+		if err != nil {
+			return err
+		}
+		if size >= b.options.QueueLimits.For(funcName) {
+			return ErrQueueFull
+		}
+	}
+
+	if err := j.SetState(job.StateQueued); err != nil {
+		return err
+	}
+
+	j.ScheduledAt = time.Now()
+	if err := b.store.Save(j); err != nil {
+		return err
+	}
+
+	if delayed {
+		_, err := conn.Do("ZADD", redisDelayedKey, redisTime(*j.RunAt), j.ID.String())
+		return err
+	}
+
+	_, err := conn.Do("LPUSH", redisQueueKey(funcName), j.ID.String())
+	return err
+}
+
+// redisTime returns t as a sorted set score, in milliseconds
+func redisTime(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Cancel cancels j and removes it from its queue
+func (b *redisBroker) Cancel(j *job.Job) error {
+
+	queued := j.State == job.StateQueued
+
+	if err := cancel(b.store, j); err != nil {
+		return err
+	}
+
+	finished(b, b.store, j)
+
+	if !queued {
+		return nil
+	}
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("LREM", redisQueueKey(j.GetCurrentFunction().Name), 0, j.ID.String())
+	conn.Send("ZREM", redisDelayedKey, j.ID.String())
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (b *redisBroker) acquireLease(j *job.Job, deadline time.Time) error {
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("ZADD", redisLeasesKey, redisTime(deadline), j.ID.String())
+	return err
+}
+
+func (b *redisBroker) releaseLease(j *job.Job) bool {
+	return b.releaseLeaseID(j.ID.String())
+}
+
+// releaseLeaseID removes a lease, only one caller can succeed among servers
+func (b *redisBroker) releaseLeaseID(jobID string) bool {
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	removed, err := redis.Int(conn.Do("ZREM", redisLeasesKey, jobID))
+	if err != nil {
+		log.Printf("broker: fail to release lease of job [%s]: %v", jobID, err)
+		return false
+	}
+
+	return removed == 1
+}
+
+// reapLoop periodically delivers again jobs whose lease expired
+// It catches jobs held by servers which stopped while processing them
+func (b *redisBroker) reapLoop() {
+	defer b.loops.Done()
+
+	tk := time.NewTicker(redisReapInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-tk.C:
+		}
+
+		if err := b.reap(); err != nil {
+			log.Printf("broker: fail to reap expired leases: %v", err)
+		}
+	}
+}
+
+func (b *redisBroker) reap() error {
+
+	conn := b.pool.Get()
+	jobIDs, err := redis.Strings(conn.Do("ZRANGEBYSCORE", redisLeasesKey, "-inf", redisTime(time.Now())))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, jobID := range jobIDs {
+		if !b.releaseLeaseID(jobID) {
+			continue
+		}
+
+		id, err := uuid.FromString(jobID)
+		if err != nil {
+			log.Printf("broker: invalid leased job ID %s: %v", jobID, err)
+			continue
+		}
+
+		j, err := b.store.Get(id)
+		if err != nil {
+			log.Printf("broker: fail to load leased job [%s]: %v", jobID, err)
+			continue
+		}
+
+		log.Printf("broker: lease of job [%s][%s] expired, delivering again", j.Name, j.ID)
+		redeliver(b, b.store, j)
+	}
+
+	return nil
+}
+
+// delayLoop periodically queues due delayed jobs
+func (b *redisBroker) delayLoop() {
+	defer b.loops.Done()
+
+	tk := time.NewTicker(redisDelayInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-tk.C:
+		}
+
+		if err := b.promote(); err != nil {
+			log.Printf("broker: fail to queue delayed jobs: %v", err)
+		}
+	}
+}
+
+// promote queues due delayed jobs, only one server queues a given job
+func (b *redisBroker) promote() error {
+
+	conn := b.pool.Get()
+	jobIDs, err := redis.Strings(conn.Do("ZRANGEBYSCORE", redisDelayedKey, "-inf", redisTime(time.Now())))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, jobID := range jobIDs {
+		conn := b.pool.Get()
+		removed, err := redis.Int(conn.Do("ZREM", redisDelayedKey, jobID))
+		conn.Close()
+		if err != nil {
+			log.Printf("broker: fail to dequeue delayed job [%s]: %v", jobID, err)
+			continue
+		}
+		if removed != 1 {
+			continue
+		}
+
+		id, err := uuid.FromString(jobID)
+		if err != nil {
+			log.Printf("broker: invalid delayed job ID %s: %v", jobID, err)
+			continue
+		}
+
+		j, err := b.store.Get(id)
+		if err != nil {
+			log.Printf("broker: fail to load delayed job [%s]: %v", jobID, err)
+			continue
+		}
+
+		redeliver(b, b.store, j)
+	}
+
+	return nil
+}
+
+// redisUnlockScript deletes a lock only if it is still owned
+var redisUnlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// lockJob locks the job jobID among servers, it waits at most redisLockTimeout
+func (b *redisBroker) lockJob(jobID uuid.UUID) (func(), error) {
+
+	key := redisLockKeyPrefix + jobID.String()
+	token := uuid.NewV4().String()
+	deadline := time.Now().Add(redisLockTimeout)
+
+	for {
+		conn := b.pool.Get()
+		_, err := redis.String(conn.Do("SET", key, token, "NX", "PX", int64(redisLockTimeout/time.Millisecond)))
+		conn.Close()
+
+		if err == nil {
+			break
+		}
+		if err != redis.ErrNil {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("cannot lock job %s", jobID)
+		}
+
+		time.Sleep(redisLockRetry)
+	}
+
+	unlock := func() {
+		conn := b.pool.Get()
+		defer conn.Close()
+
+		if _, err := redisUnlockScript.Do(conn, key, token); err != nil {
+			log.Printf("broker: fail to unlock job [%s]: %v", jobID, err)
+		}
+	}
+
+	return unlock, nil
+}
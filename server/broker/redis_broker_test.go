@@ -0,0 +1,34 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/gomodule/redigo/redis"
+	"github.com/wayt/async/server/broker"
+	"github.com/wayt/async/server/broker/brokertest"
+	"github.com/wayt/async/server/store"
+)
+
+// TestRedisBroker runs the conformance suite against the redis broker, using an in-process redis
+func TestRedisBroker(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T, options broker.Options) (broker.Broker, store.JobStore, func()) {
+
+		srv, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pool := &redis.Pool{
+			Dial: func() (redis.Conn, error) { return redis.Dial("tcp", srv.Addr()) },
+		}
+
+		s := store.NewRedisStore(pool, store.Retention{})
+
+		return broker.NewRedisBroker(pool, s, options), s, func() {
+			s.Close()
+			pool.Close()
+			srv.Close()
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/gomodule/redigo/redis"
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
+)
+
+// TestRedisPopLease tests popped jobs are leased at once, so they are queued again if their server crashes before processing them
+func TestRedisPopLease(t *testing.T) {
+
+	srv, err := miniredis.Run()
+	assert.Equal(t, err, nil)
+	defer srv.Close()
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) { return redis.Dial("tcp", srv.Addr()) },
+	}
+	defer pool.Close()
+
+	s := store.NewRedisStore(pool, store.Retention{})
+	defer s.Close()
+
+	b := NewRedisBroker(pool, s, Options{LeaseTimeout: 100 * time.Millisecond}).(*redisBroker)
+	defer b.Stop()
+
+	j := &job.Job{
+		ID:        uuid.NewV4(),
+		Name:      "test",
+		Functions: []*function.Function{{Name: "a"}},
+		State:     job.StateQueued,
+	}
+	assert.Equal(t, b.Schedule(j), nil)
+
+	// Server crashes after popping the job, before processing it
+	popped, err := b.pop([]string{redisQueueKey("a")})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, popped.ID, j.ID)
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err = redis.Int64(conn.Do("ZSCORE", redisLeasesKey, j.ID.String()))
+	assert.Equal(t, err, nil)
+
+	// Lease expires and the reaper queues the job again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		size, err := redis.Int(conn.Do("LLEN", redisQueueKey("a")))
+		assert.Equal(t, err, nil)
+		if size == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("popped job not queued again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,447 @@
+// Package condition evaluates small boolean expressions over JSON values, like
+//
+//	result.status == "needs_review" && data.amount >= 100
+//
+// Expressions are made of variable paths, string, number, true, false and null literals,
+// comparisons (== != < <= > >=), ! && || and parentheses.
+// Evaluation never fails: missing paths are null and comparing values of different types is false.
+// Expressions can also be evaluated to a value, like the list at the path data.items.
+package condition
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed condition
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses source, its paths must start with one of variables
+func Parse(source string, variables ...string) (*Expression, error) {
+
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, variables: variables}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("condition: unexpected %q at %d", t.text, t.pos)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// Eval evaluates e with variables values
+func (e *Expression) Eval(variables map[string]interface{}) bool {
+	return truthy(e.root.eval(variables))
+}
+
+// Value returns the value of e with variables values
+func (e *Expression) Value(variables map[string]interface{}) interface{} {
+	return e.root.eval(variables)
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{} // Literal value of strings and numbers
+	pos   int
+}
+
+// operators are sorted so two characters operators match first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "."}
+
+func tokenize(source string) ([]token, error) {
+
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:i], pos: start})
+
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(source) && unicode.IsDigit(rune(source[i+1]))):
+			start := i
+			i++
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("condition: invalid number %q at %d", source[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], value: n, pos: start})
+
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(source) && rune(source[i]) != c {
+				if source[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(source) {
+				return nil, fmt.Errorf("condition: unterminated string at %d", start)
+			}
+			i++
+
+			// Single quoted strings are unquoted as double quoted ones
+			quoted := source[start:i]
+			if c == '\'' {
+				quoted = `"` + strings.Replace(quoted[1:len(quoted)-1], `"`, `\"`, -1) + `"`
+			}
+			s, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("condition: invalid string %s at %d", source[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[start:i], value: s, pos: start})
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(source[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("condition: unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, text: "end", pos: len(source)}), nil
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	variables []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of operators
+func (p *parser) accept(operators ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range operators {
+		if t.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+
+	if _, ok := p.accept("("); ok {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			t := p.peek()
+			return nil, fmt.Errorf("condition: expected ) at %d, got %q", t.pos, t.text)
+		}
+		return n, nil
+	}
+
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return literalNode{t.value}, nil
+
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		}
+		return p.parsePath(t)
+	}
+
+	return nil, fmt.Errorf("condition: unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parsePath(root token) (node, error) {
+
+	known := false
+	for _, v := range p.variables {
+		if v == root.text {
+			known = true
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("condition: unknown variable %s at %d, expected one of %s", root.text, root.pos, strings.Join(p.variables, ", "))
+	}
+
+	path := pathNode{root.text}
+	for {
+		if _, ok := p.accept("."); !ok {
+			return path, nil
+		}
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, fmt.Errorf("condition: expected field name at %d, got %q", t.pos, t.text)
+		}
+		path = append(path, t.text)
+	}
+}
+
+// node is an expression tree node, evaluated to a JSON value
+type node interface {
+	eval(variables map[string]interface{}) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) interface{} {
+	return n.value
+}
+
+type pathNode []string
+
+func (n pathNode) eval(variables map[string]interface{}) interface{} {
+	value := interface{}(variables)
+	for _, field := range n {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[field]
+	}
+	return value
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(variables map[string]interface{}) interface{} {
+	return !truthy(n.operand.eval(variables))
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(variables map[string]interface{}) interface{} {
+	return truthy(n.left.eval(variables)) && truthy(n.right.eval(variables))
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(variables map[string]interface{}) interface{} {
+	return truthy(n.left.eval(variables)) || truthy(n.right.eval(variables))
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(variables map[string]interface{}) interface{} {
+	left, right := normalize(n.left.eval(variables)), normalize(n.right.eval(variables))
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+
+	// Ordering is only defined between numbers and between strings
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// normalize converts numbers to float64, as decoded from JSON
+func normalize(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return value
+}
+
+// truthy returns false for null, false, zero, empty strings, arrays and objects
+func truthy(value interface{}) bool {
+	switch v := normalize(value).(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() > 0
+	}
+	return true
+}
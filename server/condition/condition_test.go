@@ -0,0 +1,89 @@
+package condition_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/wayt/async/server/condition"
+)
+
+// TestEval tests expressions evaluation over variables
+func TestEval(t *testing.T) {
+
+	variables := map[string]interface{}{
+		"data": map[string]interface{}{
+			"amount": float64(150),
+			"count":  3,
+			"name":   "order",
+			"items":  []interface{}{},
+		},
+		"result": map[string]interface{}{
+			"status": "needs_review",
+			"ok":     true,
+		},
+	}
+
+	testCases := []struct {
+		Expression string
+		Expected   bool
+	}{
+		{Expression: `result.status == "needs_review"`, Expected: true},
+		{Expression: `result.status == 'approved'`, Expected: false},
+		{Expression: `result.status != "approved"`, Expected: true},
+		{Expression: `data.amount >= 100 && result.ok`, Expected: true},
+		{Expression: `data.amount < 100 || !result.ok`, Expected: false},
+		{Expression: `data.count == 3`, Expected: true},
+		{Expression: `data.count > -1.5`, Expected: true},
+		{Expression: `data.name < "z"`, Expected: true},
+		{Expression: `data.name > 1`, Expected: false},
+		{Expression: `data.missing == null`, Expected: true},
+		{Expression: `data.missing.deeper`, Expected: false},
+		{Expression: `data.items`, Expected: false},
+		{Expression: `!(data.amount > 100 && data.count > 5)`, Expected: true},
+		{Expression: `true`, Expected: true},
+	}
+
+	for _, c := range testCases {
+		e, err := condition.Parse(c.Expression, "data", "result")
+		assert.Equal(t, err, nil, c.Expression)
+		assert.Equal(t, e.Eval(variables), c.Expected, c.Expression)
+	}
+}
+
+// TestParseErrors tests invalid expressions are rejected
+func TestParseErrors(t *testing.T) {
+
+	testCases := []string{
+		``,
+		`result.status ==`,
+		`reslt.status == "ok"`,
+		`(data.amount > 1`,
+		`data.amount > 1)`,
+		`"unterminated`,
+		`data. == 1`,
+		`data.amount = 1`,
+		`data.amount > 1 > 2`,
+	}
+
+	for _, c := range testCases {
+		_, err := condition.Parse(c, "data", "result")
+		assert.Equal(t, err != nil, true, c)
+	}
+}
+
+// TestValue tests expressions evaluation to a value
+func TestValue(t *testing.T) {
+
+	items := []interface{}{1.0, 2.0}
+	variables := map[string]interface{}{
+		"data": map[string]interface{}{"items": items},
+	}
+
+	e, err := condition.Parse("data.items", "data")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, e.Value(variables), items)
+
+	e, err = condition.Parse("data.missing", "data")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, e.Value(variables), nil)
+}
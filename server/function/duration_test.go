@@ -0,0 +1,48 @@
+package function_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/wayt/async/server/function"
+)
+
+// TestDurationJSON tests Duration JSON decoding and encoding
+func TestDurationJSON(t *testing.T) {
+
+	testCases := []struct {
+		JSON     string
+		Expected function.Duration
+		Err      bool
+	}{
+		{JSON: `"1m30s"`, Expected: function.Duration(90 * time.Second)},
+		{JSON: `"250ms"`, Expected: function.Duration(250 * time.Millisecond)},
+		{JSON: `1000000000`, Expected: function.Duration(time.Second)},
+		{JSON: `"soon"`, Err: true},
+		{JSON: `true`, Err: true},
+	}
+
+	for _, c := range testCases {
+		var d function.Duration
+		err := json.Unmarshal([]byte(c.JSON), &d)
+		assert.Equal(t, err != nil, c.Err, c.JSON)
+		assert.Equal(t, d, c.Expected, c.JSON)
+	}
+
+	b, err := json.Marshal(function.Duration(90 * time.Second))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(b), `"1m30s"`)
+}
+
+// TestAttemptDurationJSON tests attempt durations are encoded like other durations
+func TestAttemptDurationJSON(t *testing.T) {
+
+	b, err := json.Marshal(&function.Attempt{Duration: function.Duration(1500 * time.Millisecond)})
+	assert.Equal(t, err, nil)
+
+	var raw map[string]interface{}
+	assert.Equal(t, json.Unmarshal(b, &raw), nil)
+	assert.Equal(t, raw["duration"], "1.5s")
+}
@@ -1,6 +1,15 @@
 package function
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/condition"
+)
 
 var (
 	// ErrNoRetryOption is returned by CanReschedule when a Function has no RetryOptions
@@ -10,18 +19,372 @@ var (
 	ErrRetryLimitExceded = errors.New("retry limit exceded")
 )
 
+// Backoff strategies, computing the delay before a retry
+const (
+	BackoffFixed       = "fixed"       // initial_delay before each retry
+	BackoffLinear      = "linear"      // initial_delay times the retry number
+	BackoffExponential = "exponential" // initial_delay doubled on each retry
+)
+
 // RetryOptions define retry policy for a given Function
 type RetryOptions struct {
-	RetryLimit int32 `json:"retry_limit"`
+	RetryLimit   int32    `json:"retry_limit"`
+	Backoff      string   `json:"backoff,omitempty"` // fixed when empty
+	InitialDelay Duration `json:"initial_delay,omitempty"`
+	MaxDelay     Duration `json:"max_delay,omitempty"` // no maximum when 0
+	Jitter       float64  `json:"jitter,omitempty"`    // Fraction of the delay randomly removed, from 0 to 1
+}
+
+// Validate returns an error if the options are invalid
+func (o *RetryOptions) Validate() error {
+	switch o.Backoff {
+	case "", BackoffFixed, BackoffLinear, BackoffExponential:
+	default:
+		return fmt.Errorf("unknown backoff: %s", o.Backoff)
+	}
+
+	if o.InitialDelay < 0 || o.MaxDelay < 0 {
+		return errors.New("negative retry delay")
+	}
+
+	if o.Jitter < 0 || o.Jitter > 1 {
+		return errors.New("jitter must be between 0 and 1")
+	}
+
+	return nil
 }
 
+// Delay returns the delay before the retry number retry, starting at 1
+func (o *RetryOptions) Delay(retry int32) time.Duration {
+
+	max := time.Duration(math.MaxInt64)
+	if o.MaxDelay > 0 {
+		max = time.Duration(o.MaxDelay)
+	}
+
+	delay := time.Duration(o.InitialDelay)
+	switch o.Backoff {
+	case BackoffLinear:
+		if retry > 1 {
+			if delay > max/time.Duration(retry) {
+				delay = max
+			} else {
+				delay *= time.Duration(retry)
+			}
+		}
+	case BackoffExponential:
+		for i := int32(1); i < retry; i++ {
+			if delay > max/2 {
+				delay = max
+				break
+			}
+			delay *= 2
+		}
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	if o.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * o.Jitter * float64(delay))
+	}
+
+	return delay
+}
+
+// Attempt records a single execution of a Function
+type Attempt struct {
+	WorkerID   string    `json:"worker_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   Duration  `json:"duration"`
+	Error      string    `json:"error,omitempty"`
+	Code       string    `json:"code,omitempty"` // gRPC status code of a failed execution
+}
+
+// StateSkipped is the state of functions which won't run, as a dependency didn't succeed or a condition didn't hold
+const StateSkipped = "skipped"
+
+// ConditionVariables are the variables of conditions and map expressions: job data and the result of the previous function
+var ConditionVariables = []string{"data", "result"}
+
 // Function represents a Job function
 type Function struct {
-	Name string        `json:"name"`
-	Args []interface{} `json:"args,omitempty"`
-	//TODO: Delay time.Duration // To Delay a task
+	ID           string        `json:"id,omitempty"`         // Name of the function in dependencies and jumps
+	DependsOn    []string      `json:"depends_on,omitempty"` // IDs of group functions which must succeed before this one runs
+	Name         string        `json:"name"`
+	Args         []interface{} `json:"args,omitempty"`
+	Delay        Duration      `json:"delay,omitempty"`   // Wait before running the function
+	Timeout      Duration      `json:"timeout,omitempty"` // Maximum duration of an execution, none when 0
 	RetryCount   int32         `json:"retry_count"`
 	RetryOptions *RetryOptions `json:"retry_options,omitempty"`
+	Result       interface{}   `json:"result,omitempty"` // Value returned by the last successful execution
+	Attempts     []*Attempt    `json:"attempts,omitempty"`
+	Compensate   string        `json:"compensate,omitempty"` // Function undoing this one when a later function aborts the job
+
+	// Group functions are run in parallel, each in its own job, instead of this function
+	// A group function runs as soon as the functions it depends on succeeded
+	// Quorum is the number of group functions which must succeed, all of them when 0
+	// Result of a group is the list of its functions results
+	// MaxConcurrency limits the number of group functions running at once, unlimited when 0
+	Group          []*Function `json:"group,omitempty"`
+	Quorum         int         `json:"quorum,omitempty"`
+	MaxConcurrency int         `json:"max_concurrency,omitempty"`
+
+	// Map is an expression evaluated to a list when the job reaches this function,
+	// the function then runs as a group with one function per element, given as its last argument
+	Map string `json:"map,omitempty"`
+
+	// If is a condition evaluated when the job reaches this function, the function is skipped when it doesn't hold
+	// Else is the ID of a later function the job jumps to in that case, skipping the functions in between
+	// Matched records the condition outcome
+	If      string `json:"if,omitempty"`
+	Else    string `json:"else,omitempty"`
+	Matched *bool  `json:"matched,omitempty"`
+
+	// Children are the jobs spawned by the function which its job waits for before going on
+	Children []*Child `json:"children,omitempty"`
+
+	// Job running this group function, and its state, skipped functions are in the skipped state
+	JobID *uuid.UUID `json:"job_id,omitempty"`
+	State string     `json:"state,omitempty"`
+}
+
+// Child is a job spawned by a function
+type Child struct {
+	JobID uuid.UUID `json:"job_id"`
+	State string    `json:"state"`
+}
+
+// IsGroup returns true if f runs a group of functions
+func (f *Function) IsGroup() bool {
+	return len(f.Group) > 0
+}
+
+// IsMap returns true if f runs once per element of a list
+func (f *Function) IsMap() bool {
+	return f.Map != ""
+}
+
+// Expand turns map function f into a group, with a function per element of list
+func (f *Function) Expand(list []interface{}) {
+	f.Group = make([]*Function, 0, len(list))
+	for _, element := range list {
+		args := make([]interface{}, len(f.Args), len(f.Args)+1)
+		copy(args, f.Args)
+
+		f.Group = append(f.Group, &Function{
+			Name:         f.Name,
+			Args:         append(args, element),
+			Timeout:      f.Timeout,
+			RetryOptions: f.RetryOptions,
+		})
+	}
+}
+
+// GroupQuorum returns the number of group functions which must succeed
+func (f *Function) GroupQuorum() int {
+	if f.Quorum > 0 {
+		return f.Quorum
+	}
+
+	return len(f.Group)
+}
+
+// Validate returns an error if f is invalid
+func (f *Function) Validate() error {
+
+	if f.MaxConcurrency < 0 {
+		return fmt.Errorf("function %s: negative max concurrency", f.Name)
+	}
+
+	if f.IsMap() {
+		if f.Name == "" {
+			return errors.New("map function has no name")
+		}
+		if f.IsGroup() {
+			return fmt.Errorf("map function %s: a map function has no group", f.Name)
+		}
+		if _, err := condition.Parse(f.Map, ConditionVariables...); err != nil {
+			return fmt.Errorf("map function %s: %v", f.Name, err)
+		}
+	} else if f.IsGroup() {
+		if f.Name != "" {
+			return fmt.Errorf("group %s: a group has no name", f.Name)
+		}
+		if f.Quorum < 0 || f.Quorum > len(f.Group) {
+			return fmt.Errorf("group: quorum must be between 0 and %d", len(f.Group))
+		}
+		for _, g := range f.Group {
+			if g.IsGroup() || g.IsMap() {
+				return errors.New("group: groups cannot be nested")
+			}
+			if g.If != "" {
+				return fmt.Errorf("group: function %s: conditions are only allowed on job functions", g.Name)
+			}
+			if g.Compensate != "" {
+				return fmt.Errorf("group: function %s: compensations are only allowed on job functions", g.Name)
+			}
+			if err := g.Validate(); err != nil {
+				return fmt.Errorf("group: %v", err)
+			}
+		}
+		if err := validateDependencies(f.Group); err != nil {
+			return fmt.Errorf("group: %v", err)
+		}
+	} else if f.Name == "" {
+		return errors.New("function has no name")
+	}
+
+	if f.Timeout < 0 {
+		return fmt.Errorf("function %s: negative timeout", f.Name)
+	}
+	if f.Delay < 0 {
+		return fmt.Errorf("function %s: negative delay", f.Name)
+	}
+	if f.RetryOptions != nil {
+		if err := f.RetryOptions.Validate(); err != nil {
+			return fmt.Errorf("function %s: %v", f.Name, err)
+		}
+	}
+	if f.If != "" {
+		if _, err := condition.Parse(f.If, ConditionVariables...); err != nil {
+			return fmt.Errorf("function %s: %v", f.Name, err)
+		}
+	} else if f.Else != "" {
+		return fmt.Errorf("function %s: else without condition", f.Name)
+	}
+
+	return nil
+}
+
+// ValidateJumps returns an error if the first job function has a condition,
+// or if a function jumps to an unknown or previous function
+func ValidateJumps(functions []*Function) error {
+
+	if len(functions) > 0 && functions[0].If != "" {
+		return fmt.Errorf("function %s: the first function cannot have a condition", functions[0].Name)
+	}
+
+	for i, f := range functions {
+		if f.Else == "" {
+			continue
+		}
+
+		found := false
+		for _, next := range functions[i+1:] {
+			if next.ID == f.Else {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("function %s: else must be the id of a later function, got %s", f.Name, f.Else)
+		}
+	}
+
+	return nil
+}
+
+// Graph returns functions as a single group when some of them depend on others,
+// so each one runs as soon as its dependencies succeeded. Otherwise functions are returned as is.
+func Graph(functions []*Function) []*Function {
+	for _, f := range functions {
+		if len(f.DependsOn) > 0 {
+			return []*Function{{Group: functions}}
+		}
+	}
+	return functions
+}
+
+// validateDependencies returns an error if group functions have duplicated IDs,
+// depend on unknown functions or depend on each other in a cycle
+func validateDependencies(group []*Function) error {
+
+	byID := make(map[string]*Function, len(group))
+	for _, f := range group {
+		if f.ID == "" {
+			continue
+		}
+		if _, exists := byID[f.ID]; exists {
+			return fmt.Errorf("duplicated function id %s", f.ID)
+		}
+		byID[f.ID] = f
+	}
+
+	for _, f := range group {
+		for _, id := range f.DependsOn {
+			if _, exists := byID[id]; !exists {
+				return fmt.Errorf("function %s depends on unknown function %s", f.Name, id)
+			}
+		}
+	}
+
+	// Depth first search, a function met again while visiting its dependencies is in a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int, len(byID))
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch marks[id] {
+		case visiting:
+			return fmt.Errorf("dependency cycle on function %s", id)
+		case visited:
+			return nil
+		}
+
+		marks[id] = visiting
+		for _, dep := range byID[id].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		marks[id] = visited
+
+		return nil
+	}
+
+	for id := range byID {
+		if err := visit(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset clears f execution counters, and group functions jobs, so f can run again
+func (f *Function) Reset() {
+	f.RetryCount = 0
+	f.JobID = nil
+	f.State = ""
+	f.Matched = nil
+	f.Children = nil
+
+	// Map functions are expanded again, from the list at that time
+	if f.IsMap() {
+		f.Group = nil
+		f.Result = nil
+	}
+
+	for _, g := range f.Group {
+		g.Reset()
+	}
+}
+
+// Clear resets f and drops its results and attempts, so a new job doesn't start with the runtime fields it was given
+func (f *Function) Clear() {
+	f.Reset()
+	f.Result = nil
+	f.Attempts = nil
+
+	for _, g := range f.Group {
+		g.Clear()
+	}
 }
 
 // CanReschedule returns an error if this function cannot be rescheduled
@@ -38,6 +401,29 @@ func (f *Function) CanReschedule() error {
 	return nil
 }
 
+// AddAttempt records an execution attempt of the function
+func (f *Function) AddAttempt(a *Attempt) {
+	f.Attempts = append(f.Attempts, a)
+}
+
+// LastError returns the error of the last execution attempt, empty if it succeeded
+func (f *Function) LastError() string {
+	if len(f.Attempts) == 0 {
+		return ""
+	}
+
+	return f.Attempts[len(f.Attempts)-1].Error
+}
+
+// RetryDelay returns the delay before retrying the function, after its retry count was incremented
+func (f *Function) RetryDelay() time.Duration {
+	if f.RetryOptions == nil {
+		return 0
+	}
+
+	return f.RetryOptions.Delay(f.RetryCount)
+}
+
 func (f *Function) IncrRetryCount() {
 
 	f.RetryCount += 1
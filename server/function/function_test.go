@@ -1,9 +1,12 @@
 package function_test
 
 import (
+	"math"
 	"testing"
+	"time"
 
 	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
 	"github.com/wayt/async/server/function"
 )
 
@@ -46,3 +49,212 @@ func TestCanReschedule(t *testing.T) {
 		assert.Equal(t, err, c.Expected)
 	}
 }
+
+// TestRetryDelay tests RetryOptions backoff strategies
+func TestRetryDelay(t *testing.T) {
+
+	testCases := []struct {
+		Options  function.RetryOptions
+		Retry    int32
+		Expected time.Duration
+	}{
+		{Options: function.RetryOptions{}, Retry: 3, Expected: 0},
+		{Options: function.RetryOptions{InitialDelay: function.Duration(time.Second)}, Retry: 3, Expected: time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffFixed, InitialDelay: function.Duration(time.Second)}, Retry: 3, Expected: time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffLinear, InitialDelay: function.Duration(time.Second)}, Retry: 1, Expected: time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffLinear, InitialDelay: function.Duration(time.Second)}, Retry: 3, Expected: 3 * time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffExponential, InitialDelay: function.Duration(time.Second)}, Retry: 1, Expected: time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffExponential, InitialDelay: function.Duration(time.Second)}, Retry: 4, Expected: 8 * time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffExponential, InitialDelay: function.Duration(3 * time.Second), MaxDelay: function.Duration(10 * time.Second)}, Retry: 5, Expected: 10 * time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffLinear, InitialDelay: function.Duration(time.Second), MaxDelay: function.Duration(2 * time.Second)}, Retry: 5, Expected: 2 * time.Second},
+		{Options: function.RetryOptions{Backoff: function.BackoffExponential, InitialDelay: function.Duration(time.Second)}, Retry: 100, Expected: time.Duration(math.MaxInt64)},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, c.Options.Delay(c.Retry), c.Expected)
+	}
+}
+
+// TestRetryDelayJitter tests jitter keeps delays between the jitter bounds
+func TestRetryDelayJitter(t *testing.T) {
+
+	o := function.RetryOptions{InitialDelay: function.Duration(time.Second), Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		delay := o.Delay(1)
+		assert.Equal(t, delay >= 500*time.Millisecond && delay <= time.Second, true, delay.String())
+	}
+}
+
+// TestRetryOptionsValidate tests RetryOptions validation
+func TestRetryOptionsValidate(t *testing.T) {
+
+	testCases := []struct {
+		Options function.RetryOptions
+		Valid   bool
+	}{
+		{Options: function.RetryOptions{}, Valid: true},
+		{Options: function.RetryOptions{Backoff: function.BackoffExponential, Jitter: 1}, Valid: true},
+		{Options: function.RetryOptions{Backoff: "random"}, Valid: false},
+		{Options: function.RetryOptions{InitialDelay: -1}, Valid: false},
+		{Options: function.RetryOptions{Jitter: 1.5}, Valid: false},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, c.Options.Validate() == nil, c.Valid)
+	}
+}
+
+// TestValidate tests Function validation, with groups, dependencies, conditions, compensations and maps
+func TestValidate(t *testing.T) {
+
+	group := func(quorum int, functions ...*function.Function) *function.Function {
+		return &function.Function{Group: functions, Quorum: quorum}
+	}
+	named := &function.Function{Name: "a"}
+	node := func(id string, dependsOn ...string) *function.Function {
+		return &function.Function{ID: id, Name: id, DependsOn: dependsOn}
+	}
+
+	testCases := []struct {
+		Function *function.Function
+		Valid    bool
+	}{
+		{Function: named, Valid: true},
+		{Function: &function.Function{}, Valid: false},
+		{Function: &function.Function{Name: "a", Timeout: -1}, Valid: false},
+		{Function: group(0, named, named), Valid: true},
+		{Function: group(2, named, named), Valid: true},
+		{Function: group(3, named, named), Valid: false},
+		{Function: group(0, named, group(0, named)), Valid: false},
+		{Function: group(0, named, &function.Function{}), Valid: false},
+		{Function: &function.Function{Name: "a", Group: []*function.Function{named}}, Valid: false},
+		{Function: group(0, node("a"), node("b", "a"), node("c", "a", "b")), Valid: true},
+		{Function: group(0, node("a"), node("b", "x")), Valid: false},
+		{Function: group(0, node("a"), node("a")), Valid: false},
+		{Function: group(0, node("a", "a")), Valid: false},
+		{Function: group(0, node("a", "c"), node("b", "a"), node("c", "b")), Valid: false},
+		{Function: &function.Function{Name: "a", If: `result.status == "ok"`}, Valid: true},
+		{Function: &function.Function{Name: "a", If: `result.status ==`}, Valid: false},
+		{Function: &function.Function{Name: "a", If: `status == "ok"`}, Valid: false},
+		{Function: &function.Function{Name: "a", Else: "b"}, Valid: false},
+		{Function: group(0, &function.Function{Name: "a", If: "data.a"}), Valid: false},
+		{Function: &function.Function{Name: "a", Compensate: "b"}, Valid: true},
+		{Function: group(0, &function.Function{Name: "a", Compensate: "b"}), Valid: false},
+		{Function: &function.Function{Name: "a", Map: "data.items", MaxConcurrency: 2}, Valid: true},
+		{Function: &function.Function{Map: "data.items"}, Valid: false},
+		{Function: &function.Function{Name: "a", Map: "items"}, Valid: false},
+		{Function: &function.Function{Name: "a", Map: "data.items", MaxConcurrency: -1}, Valid: false},
+		{Function: &function.Function{Name: "a", Map: "data.items", Group: []*function.Function{named}}, Valid: false},
+		{Function: group(0, &function.Function{Name: "a", Map: "data.items"}), Valid: false},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, c.Function.Validate() == nil, c.Valid)
+	}
+}
+
+// TestGraph tests functions depending on others are grouped
+func TestGraph(t *testing.T) {
+
+	a := &function.Function{ID: "a", Name: "a"}
+	b := &function.Function{ID: "b", Name: "b"}
+	c := &function.Function{Name: "c", DependsOn: []string{"a"}}
+
+	functions := function.Graph([]*function.Function{a, b})
+	assert.Equal(t, len(functions), 2)
+
+	functions = function.Graph([]*function.Function{a, b, c})
+	assert.Equal(t, len(functions), 1)
+	assert.Equal(t, functions[0].IsGroup(), true)
+	assert.Equal(t, len(functions[0].Group), 3)
+}
+
+// TestValidateJumps tests jumps must go to a later function
+func TestValidateJumps(t *testing.T) {
+
+	step := func(id, cond, jump string) *function.Function {
+		return &function.Function{ID: id, Name: "f", If: cond, Else: jump}
+	}
+
+	testCases := []struct {
+		Functions []*function.Function
+		Valid     bool
+	}{
+		{Functions: []*function.Function{step("", "", ""), step("", "data.a", "c"), step("c", "", "")}, Valid: true},
+		{Functions: []*function.Function{step("", "data.a", ""), step("", "", "")}, Valid: false},
+		{Functions: []*function.Function{step("", "", ""), step("", "data.a", "x"), step("c", "", "")}, Valid: false},
+		{Functions: []*function.Function{step("c", "", ""), step("", "data.a", "c")}, Valid: false},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, function.ValidateJumps(c.Functions) == nil, c.Valid)
+	}
+}
+
+// TestExpand tests map functions are expanded to a function per element
+func TestExpand(t *testing.T) {
+
+	f := &function.Function{
+		Name:    "a",
+		Args:    []interface{}{"prefix"},
+		Map:     "data.items",
+		Timeout: function.Duration(time.Second),
+	}
+	f.Expand([]interface{}{1.0, 2.0})
+
+	assert.Equal(t, len(f.Group), 2)
+	for i, g := range f.Group {
+		assert.Equal(t, g.Name, "a")
+		assert.Equal(t, g.Args, []interface{}{"prefix", float64(i + 1)})
+		assert.Equal(t, g.Timeout, f.Timeout)
+		assert.Equal(t, g.IsMap(), false)
+	}
+	assert.Equal(t, f.Args, []interface{}{"prefix"})
+
+	// Map functions are expanded again on restart
+	f.Reset()
+	assert.Equal(t, f.IsGroup(), false)
+}
+
+// TestClear tests runtime fields of functions and their group functions are cleared
+func TestClear(t *testing.T) {
+
+	jobID := uuid.NewV4()
+	matched := true
+	runtime := func(f *function.Function) *function.Function {
+		f.RetryCount = 2
+		f.Attempts = []*function.Attempt{{WorkerID: "worker"}}
+		f.Result = "result"
+		f.State = "succeeded"
+		f.JobID = &jobID
+		f.Matched = &matched
+		f.Children = []*function.Child{{JobID: jobID}}
+		return f
+	}
+
+	f := runtime(&function.Function{
+		Name:  "group",
+		Group: []*function.Function{runtime(&function.Function{Name: "a"})},
+	})
+	mapped := runtime(&function.Function{
+		Name:  "map",
+		Map:   "data.items",
+		Group: []*function.Function{{Name: "map"}},
+	})
+
+	f.Clear()
+	mapped.Clear()
+
+	for _, c := range []*function.Function{f, f.Group[0], mapped} {
+		assert.Equal(t, c.RetryCount, int32(0), c.Name)
+		assert.Equal(t, len(c.Attempts), 0, c.Name)
+		assert.Equal(t, c.Result, nil, c.Name)
+		assert.Equal(t, c.State, "", c.Name)
+		assert.Equal(t, c.JobID, (*uuid.UUID)(nil), c.Name)
+		assert.Equal(t, c.Matched, (*bool)(nil), c.Name)
+		assert.Equal(t, len(c.Children), 0, c.Name)
+	}
+	assert.Equal(t, len(f.Group), 1)
+	assert.Equal(t, mapped.IsGroup(), false)
+}
@@ -2,13 +2,18 @@ package server
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
 
+	"github.com/wayt/async/server/broker"
 	"github.com/wayt/async/server/function"
 	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/schedule"
+	"github.com/wayt/async/server/store"
 	"github.com/wayt/async/server/worker"
 )
 
@@ -16,12 +21,26 @@ type handler func(c *handlerContext, w http.ResponseWriter, r *http.Request)
 
 var routes = map[string]map[string]handler{
 	"POST": {
-		"/v1/job": postJob,
+		"/v1/job":                 postJob,
+		"/v1/job/{job_id}/replay": postJobRestart((*Server).Replay),
+		"/v1/job/{job_id}/retry":  postJobRestart((*Server).Retry),
+
+		"/v1/schedule":                      postSchedule,
+		"/v1/schedule/{schedule_id}/pause":  postSchedulePause(true),
+		"/v1/schedule/{schedule_id}/resume": postSchedulePause(false),
 	},
 	"GET": {
 		"/v1/worker":       getWorkers,
 		"/v1/job":          getJobs,
 		"/v1/job/{job_id}": getJob,
+		"/v1/deadletter":   getDeadLetters,
+
+		"/v1/schedule":               getSchedules,
+		"/v1/schedule/{schedule_id}": getSchedule,
+	},
+	"DELETE": {
+		"/v1/job/{job_id}":           deleteJob,
+		"/v1/schedule/{schedule_id}": deleteSchedule,
 	},
 }
 
@@ -58,6 +77,7 @@ func postJob(c *handlerContext, w http.ResponseWriter, r *http.Request) {
 		Name      string                 `json:"name" binding:"required"`
 		Functions []*function.Function   `json:"functions" binding:"required"`
 		Data      map[string]interface{} `json:"data"`
+		RunAt     *time.Time             `json:"run_at"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
@@ -65,11 +85,20 @@ func postJob(c *handlerContext, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	j, err := c.server.CreateJob(in.Name, in.Functions, in.Data)
-	if err != nil {
+	j, err := c.server.CreateJob(in.Name, in.Functions, in.Data, in.RunAt)
+	if err == broker.ErrQueueFull {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if isInvalidJob(err) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(j); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -94,7 +123,7 @@ func getWorkers(c *handlerContext, w http.ResponseWriter, r *http.Request) {
 }
 
 func getJobs(c *handlerContext, w http.ResponseWriter, r *http.Request) {
-	jobs := c.server.broker.List()
+	jobs := c.server.store.List()
 
 	result := struct {
 		Count int
@@ -120,7 +149,7 @@ func getJob(c *handlerContext, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	j, err := c.server.broker.Get(jobID)
+	j, err := c.server.store.Get(jobID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -137,3 +166,277 @@ func getJob(c *handlerContext, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func deleteJob(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+
+	jobIDStr := mux.Vars(r)["job_id"]
+
+	jobID, err := uuid.FromString(jobIDStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := c.server.Cancel(jobID)
+	switch err {
+	case nil:
+	case store.ErrJobNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case job.ErrFinished:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := struct {
+		Job *job.Job
+	}{
+		Job: j,
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getDeadLetters(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+	jobs := store.DeadLetters(c.server.store)
+
+	result := struct {
+		Count int
+		Jobs  []*job.Job
+	}{
+		Count: len(jobs),
+		Jobs:  jobs,
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// postJobRestart returns a handler scheduling a finished job again with restart
+func postJobRestart(restart func(s *Server, jobID uuid.UUID, from *int) (*job.Job, error)) handler {
+	return func(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+
+		jobIDStr := mux.Vars(r)["job_id"]
+
+		jobID, err := uuid.FromString(jobIDStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Body is optional
+		var in struct {
+			FromFunction *int `json:"from_function"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		j, err := restart(c.server, jobID, in.FromFunction)
+		switch err {
+		case nil:
+		case store.ErrJobNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case job.ErrInvalidFunction:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case job.ErrNotDeadLetter, job.ErrSucceeded, job.ErrNotFinished:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case broker.ErrQueueFull:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := struct {
+			Job *job.Job
+		}{
+			Job: j,
+		}
+
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// nextFireTimesCount is the number of next fire times returned with schedules
+const nextFireTimesCount = 5
+
+// scheduleView is a schedule with its next fire times
+type scheduleView struct {
+	*schedule.Schedule
+	NextFireTimes []time.Time `json:"next_fire_times"`
+}
+
+func newScheduleView(sch *schedule.Schedule) scheduleView {
+	return scheduleView{
+		Schedule:      sch,
+		NextFireTimes: sch.NextFireTimes(time.Now(), nextFireTimesCount),
+	}
+}
+
+func postSchedule(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name      string                 `json:"name" binding:"required"`
+		Cron      string                 `json:"cron" binding:"required"`
+		Timezone  string                 `json:"timezone"`
+		Functions []*function.Function   `json:"functions" binding:"required"`
+		Data      map[string]interface{} `json:"data"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sch, err := c.server.CreateSchedule(in.Name, in.Cron, in.Timezone, in.Functions, in.Data)
+	if isInvalidJob(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := struct {
+		Schedule scheduleView
+	}{
+		Schedule: newScheduleView(sch),
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getSchedules(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+	schedules := c.server.schedules.ListSchedules()
+
+	views := make([]scheduleView, 0, len(schedules))
+	for _, sch := range schedules {
+		views = append(views, newScheduleView(sch))
+	}
+
+	result := struct {
+		Count     int
+		Schedules []scheduleView
+	}{
+		Count:     len(views),
+		Schedules: views,
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getSchedule(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+
+	scheduleID, err := uuid.FromString(mux.Vars(r)["schedule_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sch, err := c.server.schedules.GetSchedule(scheduleID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	result := struct {
+		Schedule scheduleView
+	}{
+		Schedule: newScheduleView(sch),
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// postSchedulePause returns a handler pausing or resuming a schedule
+func postSchedulePause(paused bool) handler {
+	return func(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+
+		scheduleID, err := uuid.FromString(mux.Vars(r)["schedule_id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sch, err := c.server.PauseSchedule(scheduleID, paused)
+		switch err {
+		case nil:
+		case store.ErrScheduleNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := struct {
+			Schedule scheduleView
+		}{
+			Schedule: newScheduleView(sch),
+		}
+
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func deleteSchedule(c *handlerContext, w http.ResponseWriter, r *http.Request) {
+
+	scheduleID, err := uuid.FromString(mux.Vars(r)["schedule_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sch, err := c.server.DeleteSchedule(scheduleID)
+	switch err {
+	case nil:
+	case store.ErrScheduleNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := struct {
+		Schedule *schedule.Schedule
+	}{
+		Schedule: sch,
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
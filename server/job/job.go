@@ -1,38 +1,298 @@
 package job
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/satori/go.uuid"
+	"github.com/wayt/async/server/condition"
 	"github.com/wayt/async/server/function"
 )
 
 var (
-	ErrNotFound   = errors.New("not found")
-	ErrReschedule = errors.New("reschedule")
-	ErrAbort      = errors.New("abort")
+	ErrNotFound        = errors.New("not found")
+	ErrReschedule      = errors.New("reschedule")
+	ErrRetry           = errors.New("retry")
+	ErrAbort           = errors.New("abort")
+	ErrFail            = errors.New("fail")
+	ErrCancel          = errors.New("cancel")
+	ErrFinished        = errors.New("job is finished")
+	ErrNotFinished     = errors.New("job is not finished")
+	ErrNotDeadLetter   = errors.New("job is not a dead letter")
+	ErrSucceeded       = errors.New("job succeeded")
+	ErrInvalidFunction = errors.New("invalid function index")
 )
 
+// State represents a Job lifecycle state
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateAborted   State = "aborted"
+	StateCancelled State = "cancelled"
+)
+
+// transitions lists allowed next states for each state
+var transitions = map[State][]State{
+	StateQueued:  {StateRunning, StateCancelled},
+	StateRunning: {StateQueued, StateSucceeded, StateFailed, StateAborted, StateCancelled},
+}
+
+// IsFinal returns true if no transition is allowed from s
+func (s State) IsFinal() bool {
+	return len(transitions[s]) == 0
+}
+
 type Job struct {
 	ID              uuid.UUID              `json:"job_id"`
 	Name            string                 `json:"name"`
 	Functions       []*function.Function   `json:"functions"`
 	CurrentFunction int                    `json:"current_function"`
 	Data            map[string]interface{} `json:"data"`
+	State           State                  `json:"state"`
 	CreatedAt       time.Time              `json:"created_at"`
 	ScheduledAt     time.Time              `json:"scheduled_at"`
+	RunAt           *time.Time             `json:"run_at,omitempty"` // Current function is not run before, when set
+	StartedAt       *time.Time             `json:"started_at,omitempty"`
+	FinishedAt      *time.Time             `json:"finished_at,omitempty"`
+	Error           string                 `json:"error,omitempty"`       // Last error of a failed job
+	DeadLetter      bool                   `json:"dead_letter,omitempty"` // Failed after exhausting its retries
+	ParentID        *uuid.UUID             `json:"parent_id,omitempty"`   // Job waiting for this one
+
+	// Compensations undo the functions which ran before the job aborted or failed, they run in order instead of Functions
+	Compensations       []*function.Function `json:"compensations,omitempty"`
+	CurrentCompensation int                  `json:"current_compensation,omitempty"`
+	CompensatedState    State                `json:"compensated_state,omitempty"` // State once compensated
 }
 
+// GetCurrentFunction returns the function to run, the current compensation of a compensating job
 func (j *Job) GetCurrentFunction() *function.Function {
+	if j.IsCompensating() {
+		return j.Compensations[j.CurrentCompensation]
+	}
 	return j.Functions[j.CurrentFunction]
 }
 
-func (j *Job) IncrCurrentFunction() bool {
-	if j.CurrentFunction == len(j.Functions)-1 {
+// IsCompensating returns true if j aborted and runs compensations
+func (j *Job) IsCompensating() bool {
+	return len(j.Compensations) > 0
+}
+
+// Compensate prepares the compensations of the functions which ran before the current one, in reverse order.
+// Compensations share the retry options and timeout of the function they undo, j ends in state once they succeeded.
+// It returns false if j is already compensating or no function needs to be compensated.
+func (j *Job) Compensate(state State) bool {
+	if j.IsCompensating() {
 		return false
 	}
 
-	j.CurrentFunction += 1
-	return true
+	for i := j.CurrentFunction - 1; i >= 0; i-- {
+		f := j.Functions[i]
+		if f.Compensate == "" || f.State == function.StateSkipped {
+			continue
+		}
+
+		j.Compensations = append(j.Compensations, &function.Function{
+			Name:         f.Compensate,
+			Timeout:      f.Timeout,
+			RetryOptions: f.RetryOptions,
+		})
+	}
+
+	j.CurrentCompensation = 0
+	j.CompensatedState = state
+	return j.IsCompensating()
+}
+
+// CompletedState returns the state of j once its last function succeeded:
+// succeeded, or the state given to Compensate when it ran compensations
+func (j *Job) CompletedState() State {
+	if !j.IsCompensating() {
+		return StateSucceeded
+	}
+	if j.CompensatedState == "" {
+		return StateAborted
+	}
+	return j.CompensatedState
+}
+
+// Variables returns the variables of conditions and map expressions of the current function:
+// job data and the result of the last function which ran before
+func (j *Job) Variables() map[string]interface{} {
+
+	var result interface{}
+	for i := j.CurrentFunction - 1; i >= 0; i-- {
+		if f := j.Functions[i]; f.State != function.StateSkipped {
+			result = f.Result
+			break
+		}
+	}
+
+	return map[string]interface{}{
+		"data":   j.Data,
+		"result": result,
+	}
+}
+
+// IncrCurrentFunction moves to the next function whose condition holds, it returns false when none is left.
+// Conditions are evaluated with the job data and the current function result, their outcome is recorded
+// on the functions and skipped functions are marked as such.
+//
+// A compensating job moves to its next compensation.
+func (j *Job) IncrCurrentFunction() bool {
+
+	if j.IsCompensating() {
+		if j.CurrentCompensation == len(j.Compensations)-1 {
+			return false
+		}
+		j.CurrentCompensation++
+		return true
+	}
+
+	variables := map[string]interface{}{
+		"data":   j.Data,
+		"result": j.GetCurrentFunction().Result,
+	}
+
+	for next := j.CurrentFunction + 1; next < len(j.Functions); next++ {
+		f := j.Functions[next]
+		if f.If == "" {
+			j.CurrentFunction = next
+			return true
+		}
+
+		// Conditions are validated when the job is created
+		expr, err := condition.Parse(f.If, function.ConditionVariables...)
+		matched := err == nil && expr.Eval(variables)
+		f.Matched = &matched
+
+		if matched {
+			j.CurrentFunction = next
+			return true
+		}
+
+		f.State = function.StateSkipped
+		if f.Else == "" {
+			continue
+		}
+
+		// Functions up to the jump target are skipped, the target condition is evaluated as usual
+		for next+1 < len(j.Functions) && j.Functions[next+1].ID != f.Else {
+			next++
+			j.Functions[next].State = function.StateSkipped
+		}
+	}
+
+	return false
+}
+
+// Delay delays the current function execution by d, it runs as soon as possible when d <= 0
+func (j *Job) Delay(d time.Duration) {
+	if d <= 0 {
+		j.RunAt = nil
+		return
+	}
+
+	runAt := time.Now().Add(d)
+	j.RunAt = &runAt
+}
+
+// IsDelayed returns true if the current function must not run yet
+func (j *Job) IsDelayed() bool {
+	return j.RunAt != nil && j.RunAt.After(time.Now())
+}
+
+// SetState moves the job to state, returns an error if the transition is not allowed
+// StartedAt and FinishedAt are updated accordingly
+func (j *Job) SetState(state State) error {
+	if j.State == state {
+		return nil
+	}
+
+	allowed := false
+	for _, next := range transitions[j.State] {
+		if next == state {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("invalid job state transition from %s to %s", j.State, state)
+	}
+
+	now := time.Now()
+	if state == StateRunning && j.StartedAt == nil {
+		j.StartedAt = &now
+	}
+	if state.IsFinal() {
+		j.FinishedAt = &now
+	}
+
+	j.State = state
+	return nil
+}
+
+// Restart queues a finished job again from function from
+// Retry counters of that function and the following ones are reset, compensations are dropped
+func (j *Job) Restart(from int) error {
+	if !j.State.IsFinal() {
+		return ErrNotFinished
+	}
+
+	if from < 0 || from >= len(j.Functions) {
+		return ErrInvalidFunction
+	}
+
+	for _, f := range j.Functions[from:] {
+		f.Reset()
+	}
+
+	j.CurrentFunction = from
+	j.Compensations = nil
+	j.CurrentCompensation = 0
+	j.CompensatedState = ""
+	j.State = StateQueued
+	j.FinishedAt = nil
+	j.RunAt = nil
+	j.Error = ""
+	j.DeadLetter = false
+
+	return nil
+}
+
+// MergeResult merges an object result into job Data, making it available to the next functions
+// Other kind of results are ignored
+func (j *Job) MergeResult(result interface{}) {
+	values, ok := result.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if j.Data == nil {
+		j.Data = make(map[string]interface{}, len(values))
+	}
+
+	for key, value := range values {
+		j.Data[key] = value
+	}
+}
+
+// Clone returns a deep copy of the job
+func (j *Job) Clone() (*Job, error) {
+	raw, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &Job{}
+	if err := json.Unmarshal(raw, clone); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
 }
@@ -0,0 +1,192 @@
+package job_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+)
+
+// TestSetState tests Job state transitions
+func TestSetState(t *testing.T) {
+
+	testCases := []struct {
+		From     job.State
+		To       job.State
+		Expected bool
+	}{
+		{From: job.StateQueued, To: job.StateRunning, Expected: true},
+		{From: job.StateQueued, To: job.StateCancelled, Expected: true},
+		{From: job.StateQueued, To: job.StateSucceeded, Expected: false},
+		{From: job.StateRunning, To: job.StateQueued, Expected: true},
+		{From: job.StateRunning, To: job.StateSucceeded, Expected: true},
+		{From: job.StateRunning, To: job.StateFailed, Expected: true},
+		{From: job.StateRunning, To: job.StateAborted, Expected: true},
+		{From: job.StateSucceeded, To: job.StateQueued, Expected: false},
+		{From: job.StateCancelled, To: job.StateRunning, Expected: false},
+	}
+
+	for _, c := range testCases {
+		j := &job.Job{State: c.From}
+		err := j.SetState(c.To)
+		assert.Equal(t, err == nil, c.Expected)
+	}
+}
+
+// TestSetStateTimestamps tests StartedAt and FinishedAt are set by transitions
+func TestSetStateTimestamps(t *testing.T) {
+
+	j := &job.Job{State: job.StateQueued}
+	assert.Equal(t, j.StartedAt == nil, true)
+
+	j.SetState(job.StateRunning)
+	assert.Equal(t, j.StartedAt != nil, true)
+	assert.Equal(t, j.FinishedAt == nil, true)
+
+	j.SetState(job.StateSucceeded)
+	assert.Equal(t, j.FinishedAt != nil, true)
+}
+
+// TestDelay tests Job Delay and IsDelayed
+func TestDelay(t *testing.T) {
+
+	j := &job.Job{}
+	assert.Equal(t, j.IsDelayed(), false)
+
+	j.Delay(time.Minute)
+	assert.Equal(t, j.IsDelayed(), true)
+
+	j.Delay(0)
+	assert.Equal(t, j.RunAt == nil, true)
+	assert.Equal(t, j.IsDelayed(), false)
+
+	past := time.Now().Add(-time.Minute)
+	j.RunAt = &past
+	assert.Equal(t, j.IsDelayed(), false)
+}
+
+// TestRestart tests a finished Job restarts from a function with reset retry counters
+func TestRestart(t *testing.T) {
+
+	newJob := func(state job.State) *job.Job {
+		finishedAt := time.Now()
+		return &job.Job{
+			State:      state,
+			FinishedAt: &finishedAt,
+			Functions: []*function.Function{
+				{Name: "a", RetryCount: 1},
+				{Name: "b", RetryCount: 2},
+				{Name: "c", RetryCount: 3},
+			},
+			CurrentFunction: 2,
+			Error:           "failed",
+			DeadLetter:      true,
+		}
+	}
+
+	j := newJob(job.StateFailed)
+	assert.Equal(t, j.Restart(1), nil)
+	assert.Equal(t, j.State, job.StateQueued)
+	assert.Equal(t, j.CurrentFunction, 1)
+	assert.Equal(t, j.Functions[0].RetryCount, int32(1))
+	assert.Equal(t, j.Functions[1].RetryCount, int32(0))
+	assert.Equal(t, j.Functions[2].RetryCount, int32(0))
+	assert.Equal(t, j.FinishedAt == nil, true)
+	assert.Equal(t, j.Error, "")
+	assert.Equal(t, j.DeadLetter, false)
+
+	assert.Equal(t, newJob(job.StateFailed).Restart(3), job.ErrInvalidFunction)
+	assert.Equal(t, newJob(job.StateRunning).Restart(0), job.ErrNotFinished)
+}
+
+// TestIncrCurrentFunction tests conditions are evaluated when moving to the next function
+func TestIncrCurrentFunction(t *testing.T) {
+
+	newJob := func(status string) *job.Job {
+		return &job.Job{
+			Data: map[string]interface{}{"amount": 150.0},
+			Functions: []*function.Function{
+				{Name: "check", Result: map[string]interface{}{"status": status}},
+				{Name: "review", If: `result.status == "needs_review"`, Else: "pay"},
+				{Name: "notify"},
+				{ID: "pay", Name: "pay", If: "data.amount > 100"},
+				{Name: "refund", If: "data.amount < 0"},
+			},
+		}
+	}
+
+	// Condition holds, no function is skipped
+	j := newJob("needs_review")
+	assert.Equal(t, j.IncrCurrentFunction(), true)
+	assert.Equal(t, j.GetCurrentFunction().Name, "review")
+	assert.Equal(t, *j.GetCurrentFunction().Matched, true)
+	assert.Equal(t, j.IncrCurrentFunction(), true)
+	assert.Equal(t, j.GetCurrentFunction().Name, "notify")
+
+	// Condition doesn't hold, the job jumps to pay
+	j = newJob("approved")
+	assert.Equal(t, j.IncrCurrentFunction(), true)
+	assert.Equal(t, j.GetCurrentFunction().Name, "pay")
+	assert.Equal(t, *j.Functions[1].Matched, false)
+	assert.Equal(t, j.Functions[1].State, function.StateSkipped)
+	assert.Equal(t, j.Functions[2].State, function.StateSkipped)
+	assert.Equal(t, j.Functions[2].Matched == nil, true)
+	assert.Equal(t, *j.Functions[3].Matched, true)
+
+	// Last function is skipped, none is left
+	assert.Equal(t, j.IncrCurrentFunction(), false)
+	assert.Equal(t, j.GetCurrentFunction().Name, "pay")
+	assert.Equal(t, j.Functions[4].State, function.StateSkipped)
+}
+
+// TestCompensate tests functions which ran are compensated in reverse order
+func TestCompensate(t *testing.T) {
+
+	retry := &function.RetryOptions{RetryLimit: 3}
+	j := &job.Job{
+		State: job.StateRunning,
+		Functions: []*function.Function{
+			{Name: "reserve", Compensate: "release", RetryOptions: retry},
+			{Name: "notify"},
+			{Name: "review", Compensate: "unreview", State: function.StateSkipped},
+			{Name: "charge", Compensate: "refund"},
+			{Name: "ship", Compensate: "return"},
+		},
+		CurrentFunction: 4,
+	}
+
+	assert.Equal(t, j.IsCompensating(), false)
+	assert.Equal(t, j.Compensate(job.StateAborted), true)
+	assert.Equal(t, j.IsCompensating(), true)
+	assert.Equal(t, j.Compensate(job.StateFailed), false)
+
+	// Current function aborted, skipped functions didn't run
+	assert.Equal(t, j.GetCurrentFunction().Name, "refund")
+	assert.Equal(t, j.IncrCurrentFunction(), true)
+	assert.Equal(t, j.GetCurrentFunction().Name, "release")
+	assert.Equal(t, j.GetCurrentFunction().RetryOptions, retry)
+	assert.Equal(t, j.IncrCurrentFunction(), false)
+	assert.Equal(t, j.CompletedState(), job.StateAborted)
+
+	// Restart drops compensations
+	assert.Equal(t, j.SetState(job.StateAborted), nil)
+	assert.Equal(t, j.Restart(0), nil)
+	assert.Equal(t, j.IsCompensating(), false)
+	assert.Equal(t, j.GetCurrentFunction().Name, "reserve")
+	assert.Equal(t, j.CompletedState(), job.StateSucceeded)
+
+	// Failed jobs are compensated the same way, they end failed
+	j.CurrentFunction = 1
+	assert.Equal(t, j.Compensate(job.StateFailed), true)
+	assert.Equal(t, j.GetCurrentFunction().Name, "release")
+	assert.Equal(t, j.IncrCurrentFunction(), false)
+	assert.Equal(t, j.CompletedState(), job.StateFailed)
+
+	// Nothing to compensate
+	assert.Equal(t, j.SetState(job.StateRunning), nil)
+	assert.Equal(t, j.SetState(job.StateFailed), nil)
+	assert.Equal(t, j.Restart(0), nil)
+	assert.Equal(t, j.Compensate(job.StateAborted), false)
+}
@@ -0,0 +1,235 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression, with the usual five fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields accept *, values, ranges (1-5), steps (*/15, 1-30/5) and comma separated lists.
+// Months and days of week accept their first three letters (jan, mon), Sunday is 0 or 7.
+// When both days of month and days of week are restricted, a day matching either of them fires.
+type Cron struct {
+	minute, hour, dom, month, dow field
+
+	// Day fields set to *, a day matches both day fields when one of them is *
+	anyDom, anyDow bool
+}
+
+// field is the set of matching values of a cron field
+type field uint64
+
+func (f field) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// bounds of a cron field values
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteBounds = bounds{min: 0, max: 59}
+	hourBounds   = bounds{min: 0, max: 23}
+	domBounds    = bounds{min: 1, max: 31}
+	monthBounds  = bounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowBounds = bounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// descriptors are shortcuts for common expressions
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression, or one of the @yearly, @monthly, @weekly, @daily and @hourly descriptors
+func ParseCron(expr string) (*Cron, error) {
+
+	if strings.HasPrefix(expr, "@") {
+		descriptor, ok := descriptors[expr]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown descriptor %s", expr)
+		}
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(fields), expr)
+	}
+
+	c := &Cron{
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}
+
+	var err error
+	if c.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+
+	// Sunday is 0 or 7
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps
+func parseField(s string, b bounds) (field, error) {
+
+	var f field
+	for _, part := range strings.Split(s, ",") {
+
+		rangeExpr, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in %q", part)
+			}
+			rangeExpr, step = part[:i], n
+		}
+
+		var first, last int
+		switch {
+		case rangeExpr == "*":
+			first, last = b.min, b.max
+
+		case strings.Contains(rangeExpr, "-"):
+			i := strings.Index(rangeExpr, "-")
+			var err error
+			if first, err = parseValue(rangeExpr[:i], b); err != nil {
+				return 0, err
+			}
+			if last, err = parseValue(rangeExpr[i+1:], b); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("cron: invalid range %q", rangeExpr)
+			}
+
+		default:
+			var err error
+			if first, err = parseValue(rangeExpr, b); err != nil {
+				return 0, err
+			}
+
+			// A single value with a step runs up to the maximum, like 5/15
+			last = first
+			if step > 1 {
+				last = b.max
+			}
+		}
+
+		for v := first; v <= last; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q", s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("cron: value %d out of range %d-%d", v, b.min, b.max)
+	}
+
+	return v, nil
+}
+
+// dayMatches returns true if the day of t matches the day of month and day of week fields
+func (c *Cron) dayMatches(t time.Time) bool {
+
+	dom := c.dom.has(t.Day())
+	dow := c.dow.has(int(t.Weekday()))
+
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// maxYears bounds the search of the next time, expressions like 0 0 30 2 * never match
+const maxYears = 5
+
+// Next returns the first matching time strictly after t, in the location of t
+// It returns the zero time if the expression doesn't match within the next years.
+// Times skipped by daylight saving time don't match, times repeated by it match once.
+func (c *Cron) Next(t time.Time) time.Time {
+
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	limit := t.Year() + maxYears
+	for t.Year() <= limit {
+
+		if !c.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		// Hours are stepped in absolute time, the hour repeated when daylight saving time ends
+		// would otherwise be built again by time.Date
+		if !c.hour.has(t.Hour()) {
+			t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+			continue
+		}
+
+		if !c.minute.has(t.Minute()) || !isFirst(t) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// isFirst returns false if t is the second occurrence of its wall clock time, when daylight saving time ends
+func isFirst(t time.Time) bool {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()).Equal(t)
+}
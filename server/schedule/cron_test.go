@@ -0,0 +1,144 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/wayt/async/server/schedule"
+)
+
+// TestCronNext tests the next time matching cron expressions
+func TestCronNext(t *testing.T) {
+
+	// Wednesday
+	from := time.Date(2024, time.January, 31, 10, 30, 20, 0, time.UTC)
+
+	testCases := []struct {
+		Expression string
+		Expected   time.Time
+	}{
+		{Expression: "* * * * *", Expected: time.Date(2024, time.January, 31, 10, 31, 0, 0, time.UTC)},
+		{Expression: "*/15 * * * *", Expected: time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{Expression: "30 10 * * *", Expected: time.Date(2024, time.February, 1, 10, 30, 0, 0, time.UTC)},
+		{Expression: "0 9-17/4 * * *", Expected: time.Date(2024, time.January, 31, 13, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 29 feb *", Expected: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 * * mon,fri", Expected: time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 * * 7", Expected: time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 15 * 4", Expected: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{Expression: "5/20 * * * *", Expected: time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{Expression: "@monthly", Expected: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{Expression: "@yearly", Expected: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 30 2 *", Expected: time.Time{}},
+	}
+
+	for _, c := range testCases {
+		cron, err := schedule.ParseCron(c.Expression)
+		assert.Equal(t, err, nil, c.Expression)
+		assert.Equal(t, cron.Next(from), c.Expected, c.Expression)
+	}
+}
+
+// TestCronNextLocation tests times are matched in the location of the given time
+func TestCronNextLocation(t *testing.T) {
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	assert.Equal(t, err, nil)
+
+	cron, err := schedule.ParseCron("0 9 * * *")
+	assert.Equal(t, err, nil)
+
+	next := cron.Next(time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC).In(paris))
+	assert.Equal(t, next.UTC(), time.Date(2024, time.June, 2, 7, 0, 0, 0, time.UTC))
+}
+
+// TestCronNextDaylightSaving tests times skipped by daylight saving time don't match and repeated ones match once
+func TestCronNextDaylightSaving(t *testing.T) {
+
+	chicago, err := time.LoadLocation("America/Chicago")
+	assert.Equal(t, err, nil)
+
+	testCases := []struct {
+		Expression string
+		From       time.Time
+		Expected   []time.Time
+	}{
+		// Daylight saving time ends on November 1st at 2:00, 1:00 to 1:59 happen twice
+		{
+			Expression: "0 9 * * *",
+			From:       time.Date(2026, time.October, 31, 23, 30, 0, 0, chicago),
+			Expected: []time.Time{
+				time.Date(2026, time.November, 1, 15, 0, 0, 0, time.UTC),
+				time.Date(2026, time.November, 2, 15, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			Expression: "30 1 * * *",
+			From:       time.Date(2026, time.October, 31, 23, 30, 0, 0, chicago),
+			Expected: []time.Time{
+				time.Date(2026, time.November, 1, 6, 30, 0, 0, time.UTC),
+				time.Date(2026, time.November, 2, 7, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			Expression: "0 * * * *",
+			From:       time.Date(2026, time.November, 1, 0, 30, 0, 0, chicago),
+			Expected: []time.Time{
+				time.Date(2026, time.November, 1, 6, 0, 0, 0, time.UTC),
+				time.Date(2026, time.November, 1, 8, 0, 0, 0, time.UTC),
+			},
+		},
+		// Daylight saving time starts on March 8th at 2:00, 2:00 to 2:59 don't happen
+		{
+			Expression: "30 2 * * *",
+			From:       time.Date(2026, time.March, 7, 23, 0, 0, 0, chicago),
+			Expected: []time.Time{
+				time.Date(2026, time.March, 9, 7, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			Expression: "0 9 * * *",
+			From:       time.Date(2026, time.March, 7, 23, 0, 0, 0, chicago),
+			Expected: []time.Time{
+				time.Date(2026, time.March, 8, 14, 0, 0, 0, time.UTC),
+				time.Date(2026, time.March, 9, 14, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		cron, err := schedule.ParseCron(c.Expression)
+		assert.Equal(t, err, nil, c.Expression)
+
+		next := c.From
+		for _, expected := range c.Expected {
+			next = cron.Next(next)
+			assert.Equal(t, next.UTC(), expected, c.Expression)
+		}
+	}
+}
+
+// TestParseCronErrors tests invalid expressions are rejected
+func TestParseCronErrors(t *testing.T) {
+
+	testCases := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@often",
+	}
+
+	for _, c := range testCases {
+		_, err := schedule.ParseCron(c)
+		assert.Equal(t, err != nil, true, c)
+	}
+}
@@ -0,0 +1,131 @@
+// Package schedule defines recurring jobs, created from a template each time their cron expression fires
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/function"
+)
+
+// Schedule is a job template created at the times matching its cron expression, in its timezone
+type Schedule struct {
+	ID         uuid.UUID              `json:"schedule_id"`
+	Name       string                 `json:"name"` // Name of the created jobs
+	Cron       string                 `json:"cron"`
+	Timezone   string                 `json:"timezone,omitempty"` // IANA timezone, UTC when empty
+	Functions  []*function.Function   `json:"functions"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Paused     bool                   `json:"paused"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`             // Times before were either fired or skipped
+	LastFireAt *time.Time             `json:"last_fire_at,omitempty"` // Last time a job was created
+}
+
+// Validate checks the cron expression and timezone of s
+func (s *Schedule) Validate() error {
+
+	if s.Name == "" {
+		return errors.New("schedule name is required")
+	}
+
+	if len(s.Functions) == 0 {
+		return errors.New("schedule functions are required")
+	}
+
+	if _, err := ParseCron(s.Cron); err != nil {
+		return err
+	}
+
+	if _, err := s.location(); err != nil {
+		return fmt.Errorf("invalid timezone %s: %v", s.Timezone, err)
+	}
+
+	return nil
+}
+
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// NextFireTimes returns the n next times s fires after t, none if it is paused
+func (s *Schedule) NextFireTimes(t time.Time, n int) []time.Time {
+
+	if s.Paused {
+		return []time.Time{}
+	}
+
+	c, err := ParseCron(s.Cron)
+	if err != nil {
+		return []time.Time{}
+	}
+
+	loc, err := s.location()
+	if err != nil {
+		return []time.Time{}
+	}
+
+	times := make([]time.Time, 0, n)
+	for t = t.In(loc); len(times) < n; {
+		t = c.Next(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+
+	return times
+}
+
+// Due returns the last time s should have fired at or before now, nil if it is paused or already fired
+// Times missed while no server was running are coalesced, a single job is created for the most recent one.
+func (s *Schedule) Due(now time.Time) *time.Time {
+
+	if s.Paused {
+		return nil
+	}
+
+	c, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil
+	}
+
+	loc, err := s.location()
+	if err != nil {
+		return nil
+	}
+
+	since := s.UpdatedAt
+	if s.LastFireAt != nil && s.LastFireAt.After(since) {
+		since = *s.LastFireAt
+	}
+
+	var due *time.Time
+	for t := c.Next(since.In(loc)); !t.IsZero() && !t.After(now); t = c.Next(t) {
+		fireAt := t
+		due = &fireAt
+	}
+
+	return due
+}
+
+// Clone returns a deep copy of s, so created jobs don't share its functions and data
+func (s *Schedule) Clone() (*Schedule, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &Schedule{}
+	if err := json.Unmarshal(raw, clone); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
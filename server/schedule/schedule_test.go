@@ -0,0 +1,66 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/schedule"
+)
+
+// TestValidate tests schedules with invalid cron expressions or timezones are rejected
+func TestValidate(t *testing.T) {
+
+	functions := []*function.Function{{Name: "test"}}
+
+	testCases := []struct {
+		Schedule *schedule.Schedule
+		Valid    bool
+	}{
+		{Schedule: &schedule.Schedule{Name: "test", Cron: "@daily", Functions: functions}, Valid: true},
+		{Schedule: &schedule.Schedule{Name: "test", Cron: "0 9 * * 1-5", Timezone: "Europe/Paris", Functions: functions}, Valid: true},
+		{Schedule: &schedule.Schedule{Cron: "@daily", Functions: functions}, Valid: false},
+		{Schedule: &schedule.Schedule{Name: "test", Cron: "@daily"}, Valid: false},
+		{Schedule: &schedule.Schedule{Name: "test", Cron: "0 9 * *", Functions: functions}, Valid: false},
+		{Schedule: &schedule.Schedule{Name: "test", Cron: "@daily", Timezone: "Mars/Olympus", Functions: functions}, Valid: false},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, c.Schedule.Validate() == nil, c.Valid, c.Schedule.Cron, c.Schedule.Timezone)
+	}
+}
+
+// TestDue tests a schedule fires once for the most recent time since it last fired
+func TestDue(t *testing.T) {
+
+	updatedAt := time.Date(2024, time.January, 1, 10, 0, 30, 0, time.UTC)
+	s := &schedule.Schedule{Cron: "*/10 * * * *", UpdatedAt: updatedAt}
+
+	assert.Equal(t, s.Due(updatedAt.Add(time.Minute)), (*time.Time)(nil))
+
+	fireAt := time.Date(2024, time.January, 1, 10, 30, 0, 0, time.UTC)
+	assert.Equal(t, s.Due(fireAt.Add(time.Minute)), &fireAt)
+
+	s.LastFireAt = &fireAt
+	assert.Equal(t, s.Due(fireAt.Add(time.Minute)), (*time.Time)(nil))
+
+	s.Paused = true
+	assert.Equal(t, s.Due(fireAt.Add(time.Hour)), (*time.Time)(nil))
+}
+
+// TestNextFireTimes tests next fire times are in the schedule timezone
+func TestNextFireTimes(t *testing.T) {
+
+	s := &schedule.Schedule{Cron: "0 9 * * *", Timezone: "America/New_York"}
+
+	times := s.NextFireTimes(time.Date(2024, time.March, 9, 15, 0, 0, 0, time.UTC), 2)
+
+	// Daylight saving time starts on March 10th
+	assert.Equal(t, len(times), 2)
+	assert.Equal(t, times[0].UTC(), time.Date(2024, time.March, 10, 13, 0, 0, 0, time.UTC))
+	assert.Equal(t, times[1].UTC(), time.Date(2024, time.March, 11, 13, 0, 0, 0, time.UTC))
+
+	s.Paused = true
+	assert.Equal(t, len(s.NextFireTimes(time.Now(), 2)), 0)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/broker"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/schedule"
+)
+
+// scheduleInterval is the time between two checks of due schedules
+const scheduleInterval = 1 * time.Second
+
+// fireRetryInterval is the time between two attempts to create the job of a fire while its queue is full
+const fireRetryInterval = 1 * time.Second
+
+// CreateSchedule registers a schedule creating a job named name each time cron fires in timezone
+func (s *Server) CreateSchedule(name, cron, timezone string, functions []*function.Function, data map[string]interface{}) (*schedule.Schedule, error) {
+
+	now := time.Now()
+	sch := &schedule.Schedule{
+		ID:        uuid.NewV4(),
+		Name:      name,
+		Cron:      cron,
+		Timezone:  timezone,
+		Functions: functions,
+		Data:      data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := sch.Validate(); err != nil {
+		return nil, &invalidJobError{err: err}
+	}
+
+	// Reject functions jobs couldn't be created with, on a copy as validation sets their defaults
+	clone, err := sch.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := prepareFunctions(clone.Functions); err != nil {
+		return nil, err
+	}
+
+	if err := s.schedules.SaveSchedule(sch); err != nil {
+		return nil, err
+	}
+
+	log.Printf("server: created schedule [%s] with id [%s] at %s", sch.Name, sch.ID, sch.Cron)
+	return sch, nil
+}
+
+// PauseSchedule pauses or resumes a schedule, times it would have fired while paused are skipped
+func (s *Server) PauseSchedule(scheduleID uuid.UUID, paused bool) (*schedule.Schedule, error) {
+
+	sch, err := s.schedules.GetSchedule(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored schedules are shared
+	sch, err = sch.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	if sch.Paused != paused {
+		sch.Paused = paused
+		sch.UpdatedAt = time.Now()
+
+		if err := s.schedules.SaveSchedule(sch); err != nil {
+			return nil, err
+		}
+	}
+
+	return sch, nil
+}
+
+// DeleteSchedule deletes a schedule, jobs it created are left untouched
+func (s *Server) DeleteSchedule(scheduleID uuid.UUID) (*schedule.Schedule, error) {
+
+	sch, err := s.schedules.GetSchedule(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.schedules.DeleteSchedule(scheduleID); err != nil {
+		return nil, err
+	}
+
+	log.Printf("server: deleted schedule [%s] with id [%s]", sch.Name, sch.ID)
+	return sch, nil
+}
+
+// scheduleLoop creates the jobs of due schedules
+func (s *Server) scheduleLoop() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.fireSchedules(now)
+	}
+}
+
+// fireSchedules creates a job for each schedule due at now
+// A fire time is claimed in the store before the job is created, so servers sharing a store
+// or restarting don't create it twice. The job is created once its queue has room when it is full,
+// and is lost if it cannot be created otherwise.
+func (s *Server) fireSchedules(now time.Time) {
+
+	for _, sch := range s.schedules.ListSchedules() {
+
+		fireAt := sch.Due(now)
+		if fireAt == nil {
+			continue
+		}
+
+		claimed, err := s.schedules.ClaimFire(sch.ID, *fireAt)
+		if err != nil {
+			log.Printf("server: fail to claim schedule [%s] fire: %v", sch.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if !s.fire(sch, *fireAt) {
+			// Waiting here would delay the other schedules
+			go s.retryFire(sch, *fireAt)
+		}
+	}
+}
+
+// fire creates the job of sch fired at fireAt, it returns false if the queue is full and it must be retried
+func (s *Server) fire(sch *schedule.Schedule, fireAt time.Time) bool {
+
+	// Jobs don't share the schedule functions and data
+	template, err := sch.Clone()
+	if err != nil {
+		log.Printf("server: fail to copy schedule [%s]: %v", sch.ID, err)
+		return true
+	}
+
+	j, err := s.CreateJob(template.Name, template.Functions, template.Data, nil)
+	if err == broker.ErrQueueFull {
+		return false
+	}
+	if err != nil {
+		log.Printf("server: fail to create job of schedule [%s] at %s: %v", sch.ID, fireAt, err)
+		return true
+	}
+
+	log.Printf("server: schedule [%s] fired job [%s] at %s", sch.ID, j.ID, fireAt)
+	return true
+}
+
+// retryFire creates the job of sch fired at fireAt as soon as its queue has room
+// It gives up when sch is deleted or paused meanwhile.
+func (s *Server) retryFire(sch *schedule.Schedule, fireAt time.Time) {
+
+	log.Printf("server: queue full, schedule [%s] fire at %s delayed", sch.ID, fireAt)
+
+	tk := time.NewTicker(fireRetryInterval)
+	defer tk.Stop()
+
+	for range tk.C {
+		current, err := s.schedules.GetSchedule(sch.ID)
+		if err != nil || current.Paused {
+			log.Printf("server: schedule [%s] fire at %s dropped, schedule deleted or paused", sch.ID, fireAt)
+			return
+		}
+
+		if s.fire(current, fireAt) {
+			return
+		}
+	}
+}
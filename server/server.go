@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
 
 	uuid "github.com/satori/go.uuid"
@@ -17,8 +19,11 @@ import (
 	"github.com/wayt/async/server/broker"
 	"github.com/wayt/async/server/function"
 	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
 	"github.com/wayt/async/server/worker"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/spf13/viper"
 )
@@ -29,6 +34,16 @@ func init() {
 	config.SetEnvPrefix("async_server")
 	config.SetDefault("bind", ":8080")
 	config.SetDefault("http", ":8000")
+	config.SetDefault("broker", "memory")                          // memory or redis
+	config.SetDefault("store", "memory")                           // memory, file or redis
+	config.SetDefault("store_path", "async.db")                    // file store storage
+	config.SetDefault("redis_addr", "127.0.0.1:6379")              // redis broker and store address
+	config.SetDefault("queue_limit", broker.DefaultQueueLimit)     // default function queue limit
+	config.SetDefault("queue_limits", "")                          // per function queue limits, as name=limit,name=limit
+	config.SetDefault("lease_timeout", broker.DefaultLeaseTimeout) // time a worker owns a job
+	config.SetDefault("function_timeout", 0)                       // function execution timeout, when not set by the function, none when 0
+	config.SetDefault("retention_age", "24h")                      // finished jobs max age
+	config.SetDefault("retention_jobs", 10000)                     // finished jobs max count
 
 	config.AutomaticEnv()
 }
@@ -39,30 +54,110 @@ type Server struct {
 	workers        map[string]*worker.Worker // Registered workers
 	pendingWorkers map[string]*worker.Worker // Registration pending workers
 
-	broker broker.Broker
-	router *mux.Router
+	store     store.JobStore
+	schedules store.ScheduleStore
+	broker    broker.Broker
+	router    *mux.Router
 
 	gRPCServer *grpc.Server
 }
 
-func New() *Server {
+func New() (*Server, error) {
+
+	pool := newRedisPool(config.GetString("redis_addr"))
+
+	st, err := newStore(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newBroker(pool, st)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules, ok := st.(store.ScheduleStore)
+	if !ok {
+		return nil, fmt.Errorf("store %s doesn't support schedules", config.GetString("store"))
+	}
 
 	s := &Server{
 		workers:        make(map[string]*worker.Worker),
 		pendingWorkers: make(map[string]*worker.Worker),
-		broker:         broker.NewMemoryBroker(),
+		store:          st,
+		schedules:      schedules,
+		broker:         b,
 		gRPCServer:     grpc.NewServer(),
 	}
 
 	pb.RegisterServerServer(s.gRPCServer, s)
 	setupHandlers(s)
 
-	return s
+	return s, nil
+}
+
+// newRedisPool returns a redis connection pool, connections are established lazily
+func newRedisPool(addr string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+}
+
+// newBroker creates the broker selected by configuration
+func newBroker(pool *redis.Pool, st store.JobStore) (broker.Broker, error) {
+
+	limits, err := broker.ParseQueueLimits(config.GetInt("queue_limit"), config.GetString("queue_limits"))
+	if err != nil {
+		return nil, err
+	}
+
+	options := broker.Options{
+		QueueLimits:  limits,
+		LeaseTimeout: config.GetDuration("lease_timeout"),
+	}
+
+	switch name := config.GetString("broker"); name {
+	case "memory":
+		return broker.NewMemoryBroker(st, options), nil
+	case "redis":
+		// Jobs popped from shared queues must be found in the store
+		if config.GetString("store") != "redis" {
+			return nil, errors.New("redis broker requires redis store")
+		}
+		return broker.NewRedisBroker(pool, st, options), nil
+	default:
+		return nil, fmt.Errorf("unknown broker: %s", name)
+	}
+}
+
+// newStore creates the job store selected by configuration
+func newStore(pool *redis.Pool) (store.JobStore, error) {
+
+	retention := store.Retention{
+		MaxAge:  config.GetDuration("retention_age"),
+		MaxJobs: config.GetInt("retention_jobs"),
+	}
+
+	switch name := config.GetString("store"); name {
+	case "memory":
+		return store.NewMemoryStore(retention), nil
+	case "file":
+		return store.NewFileStore(config.GetString("store_path"), retention)
+	case "redis":
+		return store.NewRedisStore(pool, retention), nil
+	default:
+		return nil, fmt.Errorf("unknown store: %s", name)
+	}
 }
 
 func (s *Server) Run() error {
 
 	go func() { log.Fatal(http.ListenAndServe(config.GetString("http"), s.router)) }()
+	go s.scheduleLoop()
 
 	bind := config.GetString("bind")
 
@@ -79,10 +174,32 @@ func (s *Server) Run() error {
 	return nil
 }
 
-func (s *Server) CreateJob(name string, functions []*function.Function, data map[string]interface{}) (*job.Job, error) {
+// CreateJob schedules a new job, its first function runs after runAt when not nil
+func (s *Server) CreateJob(name string, functions []*function.Function, data map[string]interface{}, runAt *time.Time) (*job.Job, error) {
+	return s.createJob(name, functions, data, runAt, nil)
+}
 
-	if len(functions) == 0 {
-		return nil, fmt.Errorf("cannot create a job with empty functions")
+// CreateChildJob schedules a new job, child of the unfinished job parentID
+// The parent goes on without waiting for it, unless the spawning function reports it waits for it
+func (s *Server) CreateChildJob(parentID uuid.UUID, name string, functions []*function.Function, data map[string]interface{}) (*job.Job, error) {
+
+	parent, err := s.store.Get(parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if parent.State.IsFinal() {
+		return nil, job.ErrFinished
+	}
+
+	return s.createJob(name, functions, data, nil, &parentID)
+}
+
+func (s *Server) createJob(name string, functions []*function.Function, data map[string]interface{}, runAt *time.Time, parentID *uuid.UUID) (*job.Job, error) {
+
+	functions, err := prepareFunctions(functions)
+	if err != nil {
+		return nil, err
 	}
 
 	j := &job.Job{
@@ -91,8 +208,17 @@ func (s *Server) CreateJob(name string, functions []*function.Function, data map
 		Functions:       functions,
 		Data:            data,
 		CurrentFunction: 0,
+		State:           job.StateQueued,
 		CreatedAt:       time.Now(),
+		ParentID:        parentID,
+	}
+
+	// First function delay starts at runAt
+	start := j.CreatedAt
+	if runAt != nil && runAt.After(start) {
+		start = *runAt
 	}
+	j.Delay(start.Sub(j.CreatedAt) + time.Duration(functions[0].Delay))
 
 	if err := s.broker.Schedule(j); err != nil {
 		return nil, err
@@ -102,6 +228,163 @@ func (s *Server) CreateJob(name string, functions []*function.Function, data map
 	return j, nil
 }
 
+// invalidJobError is returned when a new job or schedule is rejected, as opposed to failing to schedule or save it
+type invalidJobError struct {
+	err error
+}
+
+func (e *invalidJobError) Error() string {
+	return e.err.Error()
+}
+
+// isInvalidJob returns true if err rejects a new job or schedule
+func isInvalidJob(err error) bool {
+	_, ok := err.(*invalidJobError)
+	return ok
+}
+
+// prepareFunctions validates the functions of a new job, clears their runtime fields and sets their defaults
+func prepareFunctions(functions []*function.Function) ([]*function.Function, error) {
+
+	if len(functions) == 0 {
+		return nil, &invalidJobError{err: errors.New("cannot create a job with empty functions")}
+	}
+
+	// Functions depending on each other run as a group
+	functions = function.Graph(functions)
+
+	if err := function.ValidateJumps(functions); err != nil {
+		return nil, &invalidJobError{err: err}
+	}
+
+	for _, f := range functions {
+		// Validated as submitted, clearing drops the group of a map function
+		if err := f.Validate(); err != nil {
+			return nil, &invalidJobError{err: err}
+		}
+		f.Clear()
+		setDefaultTimeout(f)
+	}
+
+	return functions, nil
+}
+
+// setDefaultTimeout sets the configured timeout to f and its group functions if they have none
+func setDefaultTimeout(f *function.Function) {
+	if f.Timeout == 0 && !f.IsGroup() {
+		f.Timeout = function.Duration(config.GetDuration("function_timeout"))
+	}
+
+	for _, g := range f.Group {
+		setDefaultTimeout(g)
+	}
+}
+
+// Cancel cancels a job, a running job function is cancelled on its worker
+func (s *Server) Cancel(jobID uuid.UUID) (*job.Job, error) {
+
+	j, err := s.store.Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	running := j.State == job.StateRunning
+
+	if err := s.broker.Cancel(j); err != nil {
+		return nil, err
+	}
+
+	// Jobs running on workers of other servers are stopped once their current function returns
+	if running {
+		for _, w := range s.listActiveWorkers() {
+			if w.Cancel(j.ID) {
+				break
+			}
+		}
+	}
+
+	// Group functions run in their own jobs
+	if f := j.GetCurrentFunction(); running && f.IsGroup() {
+		for _, g := range f.Group {
+			if g.JobID == nil {
+				continue
+			}
+			if _, err := s.Cancel(*g.JobID); err != nil && err != job.ErrFinished {
+				log.Printf("server: fail to cancel group job [%s]: %v", g.JobID, err)
+			}
+		}
+	}
+
+	// So do the children it waits for
+	if running {
+		for _, c := range j.GetCurrentFunction().Children {
+			if _, err := s.Cancel(c.JobID); err != nil && err != job.ErrFinished {
+				log.Printf("server: fail to cancel child job [%s]: %v", c.JobID, err)
+			}
+		}
+	}
+
+	log.Printf("server: cancelled job [%s] with id [%s]", j.Name, j.ID)
+	return j, nil
+}
+
+// Replay schedules a dead letter job again, from function index from or from its failed function when nil
+func (s *Server) Replay(jobID uuid.UUID, from *int) (*job.Job, error) {
+
+	j, err := s.store.Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !j.DeadLetter {
+		return nil, job.ErrNotDeadLetter
+	}
+
+	return s.restart(j, from)
+}
+
+// Retry schedules a failed, aborted or cancelled job again, from function index from or from its last function when nil
+// Functions before from are not run again
+func (s *Server) Retry(jobID uuid.UUID, from *int) (*job.Job, error) {
+
+	j, err := s.store.Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.State == job.StateSucceeded {
+		return nil, job.ErrSucceeded
+	}
+
+	return s.restart(j, from)
+}
+
+// restart schedules finished job j again from function index from, or from its current function when nil
+func (s *Server) restart(j *job.Job, from *int) (*job.Job, error) {
+
+	// Stored job is left untouched if it cannot be scheduled
+	j, err := j.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	index := j.CurrentFunction
+	if from != nil {
+		index = *from
+	}
+
+	if err := j.Restart(index); err != nil {
+		return nil, err
+	}
+
+	if err := s.broker.Schedule(j); err != nil {
+		return nil, err
+	}
+
+	log.Printf("server: restarting job [%s] with id [%s] from function %d", j.Name, j.ID, index)
+	return j, nil
+}
+
 func (s *Server) RegisterWorker(ctx context.Context, in *pb.RegisterWorkerRequest) (*pb.RegisterWorkerReply, error) {
 	if in.Address == "" {
 		return nil, errors.New("missing address")
@@ -122,6 +405,76 @@ func (s *Server) RegisterWorker(ctx context.Context, in *pb.RegisterWorkerReques
 	}, nil
 }
 
+func (s *Server) CancelJob(ctx context.Context, in *pb.CancelJobRequest) (*pb.CancelJobReply, error) {
+
+	jobID, err := uuid.FromString(in.JobId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	j, err := s.Cancel(jobID)
+	switch err {
+	case nil:
+	case store.ErrJobNotFound:
+		return nil, status.Error(codes.NotFound, err.Error())
+	case job.ErrFinished:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return nil, err
+	}
+
+	return &pb.CancelJobReply{
+		State: string(j.State),
+	}, nil
+}
+
+func (s *Server) SubmitJob(ctx context.Context, in *pb.SubmitJobRequest) (*pb.SubmitJobReply, error) {
+
+	var functions []*function.Function
+	if err := json.Unmarshal(in.Functions, &functions); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid functions: %v", err)
+	}
+
+	var data map[string]interface{}
+	if len(in.Data) > 0 {
+		if err := json.Unmarshal(in.Data, &data); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid data: %v", err)
+		}
+	}
+
+	var j *job.Job
+	var err error
+	if in.ParentId == "" {
+		j, err = s.CreateJob(in.Name, functions, data, nil)
+	} else {
+		parentID, parseErr := uuid.FromString(in.ParentId)
+		if parseErr != nil {
+			return nil, status.Error(codes.InvalidArgument, parseErr.Error())
+		}
+		j, err = s.CreateChildJob(parentID, in.Name, functions, data)
+	}
+
+	switch {
+	case err == nil:
+	case isInvalidJob(err):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err == store.ErrJobNotFound:
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err == job.ErrFinished:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err == broker.ErrQueueFull:
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		// Store and broker failures
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.SubmitJobReply{
+		JobId: j.ID.String(),
+		State: string(j.State),
+	}, nil
+}
+
 func (s *Server) connectWorker(w *worker.Worker) {
 
 	err := w.Connect()
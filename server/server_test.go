@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	pb "github.com/wayt/async/pb/server"
+	"github.com/wayt/async/server/broker"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/schedule"
+	"github.com/wayt/async/server/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scheduleBroker is a broker failing to schedule jobs with err
+type scheduleBroker struct {
+	broker.Broker
+	err error
+}
+
+func (b *scheduleBroker) Schedule(*job.Job) error {
+	return b.err
+}
+
+// TestSubmitJobErrors tests only rejected jobs are answered with an invalid argument
+func TestSubmitJobErrors(t *testing.T) {
+
+	testCases := []struct {
+		Request  *pb.SubmitJobRequest
+		Err      error
+		Expected codes.Code
+	}{
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[{"name": "test"}]`)}, Expected: codes.OK},
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[]`)}, Expected: codes.InvalidArgument},
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[{"name": "test", "max_concurrency": -1}]`)}, Expected: codes.InvalidArgument},
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[{"name": "test", "map": "data.items", "group": [{"name": "a"}]}]`)}, Expected: codes.InvalidArgument},
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[{"name": "test"}]`), ParentId: uuid.NewV4().String()}, Expected: codes.NotFound},
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[{"name": "test"}]`)}, Err: broker.ErrQueueFull, Expected: codes.ResourceExhausted},
+		{Request: &pb.SubmitJobRequest{Name: "test", Functions: []byte(`[{"name": "test"}]`)}, Err: errors.New("connection refused"), Expected: codes.Internal},
+	}
+
+	for _, c := range testCases {
+		s := &Server{
+			store:  store.NewMemoryStore(store.Retention{}),
+			broker: &scheduleBroker{err: c.Err},
+		}
+
+		_, err := s.SubmitJob(context.Background(), c.Request)
+		assert.Equal(t, status.Code(err), c.Expected, string(c.Request.Functions))
+
+		s.store.Close()
+	}
+}
+
+// saveScheduleStore is a schedule store failing to save schedules with err
+type saveScheduleStore struct {
+	store.ScheduleStore
+	err error
+}
+
+func (s *saveScheduleStore) SaveSchedule(*schedule.Schedule) error {
+	return s.err
+}
+
+// TestCreateScheduleErrors tests only rejected schedules are reported as invalid
+func TestCreateScheduleErrors(t *testing.T) {
+
+	testCases := []struct {
+		Cron      string
+		Timezone  string
+		Functions []*function.Function
+		Err       error
+		Invalid   bool
+	}{
+		{Cron: "* * * * *", Functions: []*function.Function{{Name: "test"}}},
+		{Cron: "invalid", Functions: []*function.Function{{Name: "test"}}, Invalid: true},
+		{Cron: "* * * * *", Timezone: "Invalid/Zone", Functions: []*function.Function{{Name: "test"}}, Invalid: true},
+		{Cron: "* * * * *", Functions: []*function.Function{{Name: "test", MaxConcurrency: -1}}, Invalid: true},
+		{Cron: "* * * * *", Functions: []*function.Function{{Name: "test"}}, Err: errors.New("disk full")},
+	}
+
+	for _, c := range testCases {
+		s := &Server{schedules: &saveScheduleStore{err: c.Err}}
+
+		_, err := s.CreateSchedule("test", c.Cron, c.Timezone, c.Functions, nil)
+		assert.Equal(t, isInvalidJob(err), c.Invalid, c.Cron, c.Timezone)
+		assert.Equal(t, err != nil, c.Invalid || c.Err != nil, c.Cron, c.Timezone)
+	}
+}
+
+// fullBroker is a broker whose queue is full for the first full jobs scheduled
+type fullBroker struct {
+	broker.Broker
+	full      int
+	scheduled chan *job.Job
+}
+
+func (b *fullBroker) Schedule(j *job.Job) error {
+	if b.full > 0 {
+		b.full--
+		return broker.ErrQueueFull
+	}
+	b.scheduled <- j
+	return nil
+}
+
+// TestFireSchedulesQueueFull tests a claimed fire creates its job once the queue has room
+func TestFireSchedulesQueueFull(t *testing.T) {
+
+	st := store.NewMemoryStore(store.Retention{})
+	defer st.Close()
+
+	b := &fullBroker{full: 2, scheduled: make(chan *job.Job, 1)}
+	s := &Server{
+		store:     st,
+		schedules: st.(store.ScheduleStore),
+		broker:    b,
+	}
+
+	now := time.Now()
+	sch := &schedule.Schedule{
+		ID:        uuid.NewV4(),
+		Name:      "test",
+		Cron:      "* * * * *",
+		Functions: []*function.Function{{Name: "test"}},
+		CreatedAt: now.Add(-time.Hour),
+		UpdatedAt: now.Add(-time.Hour),
+	}
+	assert.Equal(t, s.schedules.SaveSchedule(sch), nil)
+
+	s.fireSchedules(now)
+
+	select {
+	case j := <-b.scheduled:
+		assert.Equal(t, j.Name, "test")
+	case <-time.After(5 * fireRetryInterval):
+		t.Fatal("job of the fire not created")
+	}
+
+	// The fire was claimed, it doesn't create another job
+	s.fireSchedules(now)
+	select {
+	case <-b.scheduled:
+		t.Fatal("fire created twice")
+	case <-time.After(2 * fireRetryInterval):
+	}
+}
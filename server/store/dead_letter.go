@@ -0,0 +1,24 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/wayt/async/server/job"
+)
+
+// DeadLetters returns the jobs of s which failed after exhausting their retries, most recent first
+// Dead letters stay in the store, out of the retention policy, until they are replayed.
+// Jobs running their compensations are listed once finished.
+func DeadLetters(s JobStore) []*job.Job {
+
+	var jobs []*job.Job
+	for _, j := range s.List() {
+		if j.DeadLetter && j.State.IsFinal() {
+			jobs = append(jobs, j)
+		}
+	}
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].FinishedAt.After(*jobs[k].FinishedAt) })
+
+	return jobs
+}
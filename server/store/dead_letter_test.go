@@ -0,0 +1,33 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+)
+
+// TestDeadLetters tests only finished dead letters are listed, most recent first
+func TestDeadLetters(t *testing.T) {
+
+	s := NewMemoryStore(Retention{})
+	defer s.Close()
+
+	now := time.Now()
+	finishedAt := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+
+	failed := &job.Job{ID: uuid.NewV4(), State: job.StateFailed, FinishedAt: finishedAt(time.Minute)}
+	older := &job.Job{ID: uuid.NewV4(), State: job.StateFailed, FinishedAt: finishedAt(time.Hour), DeadLetter: true}
+	recent := &job.Job{ID: uuid.NewV4(), State: job.StateFailed, FinishedAt: finishedAt(time.Second), DeadLetter: true}
+
+	// Dead letter running its compensations
+	compensating := &job.Job{ID: uuid.NewV4(), State: job.StateRunning, DeadLetter: true}
+
+	for _, j := range []*job.Job{failed, older, recent, compensating} {
+		s.Save(j)
+	}
+
+	assert.Equal(t, DeadLetters(s), []*job.Job{recent, older})
+}
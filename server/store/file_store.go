@@ -0,0 +1,325 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/schedule"
+)
+
+// fileRecord is a single entry of the file store log
+type fileRecord struct {
+	Job     *job.Job   `json:"job,omitempty"`
+	Deleted *uuid.UUID `json:"deleted,omitempty"` // ID of a deleted job
+
+	Schedule        *schedule.Schedule `json:"schedule,omitempty"`
+	DeletedSchedule *uuid.UUID         `json:"deleted_schedule,omitempty"` // ID of a deleted schedule
+}
+
+// Log records written before the file store is compacted while running,
+// once the log holds more than compactRatio records per live job and schedule
+const (
+	compactMinRecords = 1000
+	compactRatio      = 4
+)
+
+// File backed job store
+// Every Save is appended to a log file, which is replayed and compacted when the store is opened,
+// and compacted again when it grows too large compared to the live jobs and schedules.
+type fileStore struct {
+	sync.RWMutex
+	stop    chan struct{}
+	path    string
+	file    *os.File
+	records int // Records in the log file
+	jobs    map[string]*job.Job
+
+	// Stored schedules are never mutated, they are replaced by updated copies
+	schedules map[string]*schedule.Schedule
+}
+
+// NewFileStore opens the job store persisted in the file at path
+func NewFileStore(path string, retention Retention) (JobStore, error) {
+
+	s, err := openFileStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	go gcLoop(s, retention, s.stop)
+
+	return s, nil
+}
+
+func openFileStore(path string) (*fileStore, error) {
+
+	s := &fileStore{
+		stop:      make(chan struct{}),
+		path:      path,
+		jobs:      make(map[string]*job.Job),
+		schedules: make(map[string]*schedule.Schedule),
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replay loads jobs and schedules from the log file, the last record of a job or schedule wins
+func (s *fileStore) replay() error {
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A write may have been interrupted, the records after it are still valid
+			log.Printf("store: skip corrupt record at %s:%d: %v", s.path, line, err)
+			continue
+		}
+
+		s.apply(&r)
+	}
+
+	return scanner.Err()
+}
+
+// apply updates the jobs and schedules with r
+func (s *fileStore) apply(r *fileRecord) {
+
+	if r.Job != nil {
+		s.jobs[r.Job.ID.String()] = r.Job
+	}
+	if r.Deleted != nil {
+		delete(s.jobs, r.Deleted.String())
+	}
+	if r.Schedule != nil {
+		s.schedules[r.Schedule.ID.String()] = r.Schedule
+	}
+	if r.DeletedSchedule != nil {
+		delete(s.schedules, r.DeletedSchedule.String())
+	}
+}
+
+// compact rewrites the log file with a single record per job and schedule
+func (s *fileStore) compact() error {
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range s.jobs {
+		if err := writeRecord(tmp, &fileRecord{Job: j}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	for _, sch := range s.schedules {
+		if err := writeRecord(tmp, &fileRecord{Schedule: sch}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.records = len(s.jobs) + len(s.schedules)
+
+	return nil
+}
+
+func writeRecord(f *os.File, r *fileRecord) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(raw, '\n'))
+	return err
+}
+
+// append durably writes r at the end of the log file and applies it, the log is compacted when it grew too large
+func (s *fileStore) append(r *fileRecord) error {
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := writeRecord(s.file, r); err != nil {
+		s.truncate(info.Size())
+		return err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		s.truncate(info.Size())
+		return err
+	}
+
+	s.apply(r)
+
+	s.records++
+	if s.records > compactMinRecords && s.records > compactRatio*(len(s.jobs)+len(s.schedules)) {
+		// The record is already written, compaction is attempted again on the next append
+		if err := s.compact(); err != nil {
+			log.Printf("store: fail to compact %s: %v", s.path, err)
+		}
+	}
+
+	return nil
+}
+
+// truncate drops a partially written record from the end of the log file, so the next records don't follow it on its line
+func (s *fileStore) truncate(size int64) {
+	if err := s.file.Truncate(size); err != nil {
+		log.Printf("store: fail to truncate %s: %v", s.path, err)
+	}
+}
+
+func (s *fileStore) Save(j *job.Job) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.append(&fileRecord{Job: j})
+}
+
+func (s *fileStore) Get(jobID uuid.UUID) (*job.Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	j, ok := s.jobs[jobID.String()]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return j, nil
+}
+
+func (s *fileStore) List() []*job.Job {
+	s.RLock()
+	defer s.RUnlock()
+
+	jobs := make([]*job.Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+
+	return jobs
+}
+
+func (s *fileStore) Delete(jobID uuid.UUID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.append(&fileRecord{Deleted: &jobID})
+}
+
+func (s *fileStore) SaveSchedule(sch *schedule.Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+
+	sch = laterFire(sch, s.schedules[sch.ID.String()])
+	return s.append(&fileRecord{Schedule: sch})
+}
+
+func (s *fileStore) GetSchedule(scheduleID uuid.UUID) (*schedule.Schedule, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sch, ok := s.schedules[scheduleID.String()]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+
+	return sch, nil
+}
+
+func (s *fileStore) ListSchedules() []*schedule.Schedule {
+	s.RLock()
+	defer s.RUnlock()
+
+	schedules := make([]*schedule.Schedule, 0, len(s.schedules))
+	for _, sch := range s.schedules {
+		schedules = append(schedules, sch)
+	}
+
+	return schedules
+}
+
+func (s *fileStore) DeleteSchedule(scheduleID uuid.UUID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.append(&fileRecord{DeletedSchedule: &scheduleID})
+}
+
+// ClaimFire durably records the fire time, so a reopened store doesn't fire it again
+func (s *fileStore) ClaimFire(scheduleID uuid.UUID, t time.Time) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := s.schedules[scheduleID.String()]
+	if !ok {
+		return false, ErrScheduleNotFound
+	}
+
+	fired := claimFire(stored, t)
+	if fired == nil {
+		return false, nil
+	}
+
+	if err := s.append(&fileRecord{Schedule: fired}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *fileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	close(s.stop)
+	return s.file.Close()
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+)
+
+// TestFileStoreReopen tests jobs are restored when a file store is reopened
+func TestFileStoreReopen(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "async")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "async.db")
+
+	s, err := openFileStore(path)
+	assert.Equal(t, err, nil)
+
+	j := &job.Job{
+		ID:        uuid.NewV4(),
+		Name:      "test",
+		Functions: []*function.Function{{Name: "test"}},
+		State:     job.StateQueued,
+	}
+	assert.Equal(t, s.Save(j), nil)
+
+	j.State = job.StateSucceeded
+	assert.Equal(t, s.Save(j), nil)
+
+	deleted := &job.Job{
+		ID:    uuid.NewV4(),
+		State: job.StateQueued,
+	}
+	assert.Equal(t, s.Save(deleted), nil)
+	assert.Equal(t, s.Delete(deleted.ID), nil)
+	assert.Equal(t, s.Close(), nil)
+
+	s, err = openFileStore(path)
+	assert.Equal(t, err, nil)
+	defer s.Close()
+
+	assert.Equal(t, len(s.List()), 1)
+
+	restored, err := s.Get(j.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, restored.Name, j.Name)
+	assert.Equal(t, restored.State, job.StateSucceeded)
+}
+
+// TestFileStoreCompact tests the log file is compacted while the store is open
+func TestFileStoreCompact(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "async")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "async.db")
+
+	s, err := openFileStore(path)
+	assert.Equal(t, err, nil)
+
+	j := &job.Job{
+		ID:        uuid.NewV4(),
+		Name:      "test",
+		Functions: []*function.Function{{Name: "test"}},
+		State:     job.StateQueued,
+	}
+	assert.Equal(t, s.Save(j), nil)
+
+	info, err := os.Stat(path)
+	assert.Equal(t, err, nil)
+	size := info.Size()
+
+	for i := 0; i < compactMinRecords; i++ {
+		assert.Equal(t, s.Save(j), nil)
+	}
+
+	info, err = os.Stat(path)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, info.Size(), size)
+
+	// Records are appended to the compacted file
+	j.State = job.StateSucceeded
+	assert.Equal(t, s.Save(j), nil)
+	assert.Equal(t, s.Close(), nil)
+
+	s, err = openFileStore(path)
+	assert.Equal(t, err, nil)
+	defer s.Close()
+
+	restored, err := s.Get(j.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, restored.State, job.StateSucceeded)
+}
+
+// TestFileStoreCorruptRecord tests records following a corrupt one are restored
+func TestFileStoreCorruptRecord(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "async")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "async.db")
+
+	file, err := os.Create(path)
+	assert.Equal(t, err, nil)
+
+	a := &job.Job{ID: uuid.NewV4(), Name: "a", State: job.StateQueued}
+	b := &job.Job{ID: uuid.NewV4(), Name: "b", State: job.StateQueued}
+
+	assert.Equal(t, writeRecord(file, &fileRecord{Job: a}), nil)
+	_, err = file.WriteString("{\"job\": {\"job_id\"\n")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, writeRecord(file, &fileRecord{Job: b}), nil)
+	assert.Equal(t, file.Close(), nil)
+
+	s, err := openFileStore(path)
+	assert.Equal(t, err, nil)
+	defer s.Close()
+
+	assert.Equal(t, len(s.List()), 2)
+
+	for _, j := range []*job.Job{a, b} {
+		restored, err := s.Get(j.ID)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, restored.Name, j.Name)
+	}
+}
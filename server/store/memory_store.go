@@ -0,0 +1,136 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/schedule"
+)
+
+// In memory job store
+type memoryStore struct {
+	sync.RWMutex
+	stop chan struct{}
+	jobs map[string]*job.Job
+
+	// Stored schedules are never mutated, they are replaced by updated copies
+	schedules map[string]*schedule.Schedule
+}
+
+func NewMemoryStore(retention Retention) JobStore {
+
+	s := &memoryStore{
+		stop:      make(chan struct{}),
+		jobs:      make(map[string]*job.Job),
+		schedules: make(map[string]*schedule.Schedule),
+	}
+
+	go gcLoop(s, retention, s.stop)
+
+	return s
+}
+
+func (s *memoryStore) Save(j *job.Job) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.jobs[j.ID.String()] = j
+	return nil
+}
+
+func (s *memoryStore) Get(jobID uuid.UUID) (*job.Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	j, ok := s.jobs[jobID.String()]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return j, nil
+}
+
+func (s *memoryStore) List() []*job.Job {
+	s.RLock()
+	defer s.RUnlock()
+
+	jobs := make([]*job.Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+
+	return jobs
+}
+
+func (s *memoryStore) Delete(jobID uuid.UUID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.jobs, jobID.String())
+	return nil
+}
+
+func (s *memoryStore) SaveSchedule(sch *schedule.Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.schedules[sch.ID.String()] = laterFire(sch, s.schedules[sch.ID.String()])
+	return nil
+}
+
+func (s *memoryStore) GetSchedule(scheduleID uuid.UUID) (*schedule.Schedule, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sch, ok := s.schedules[scheduleID.String()]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+
+	return sch, nil
+}
+
+func (s *memoryStore) ListSchedules() []*schedule.Schedule {
+	s.RLock()
+	defer s.RUnlock()
+
+	schedules := make([]*schedule.Schedule, 0, len(s.schedules))
+	for _, sch := range s.schedules {
+		schedules = append(schedules, sch)
+	}
+
+	return schedules
+}
+
+func (s *memoryStore) DeleteSchedule(scheduleID uuid.UUID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.schedules, scheduleID.String())
+	return nil
+}
+
+func (s *memoryStore) ClaimFire(scheduleID uuid.UUID, t time.Time) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := s.schedules[scheduleID.String()]
+	if !ok {
+		return false, ErrScheduleNotFound
+	}
+
+	fired := claimFire(stored, t)
+	if fired == nil {
+		return false, nil
+	}
+
+	s.schedules[scheduleID.String()] = fired
+	return true, nil
+}
+
+func (s *memoryStore) Close() error {
+	close(s.stop)
+	return nil
+}
@@ -0,0 +1,288 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/schedule"
+)
+
+const (
+	redisJobsKey      = "async:jobs" // Set of all job IDs
+	redisJobKeyPrefix = "async:job:" // Job JSON, by ID
+
+	redisSchedulesKey          = "async:schedules"      // Set of all schedule IDs
+	redisScheduleKeyPrefix     = "async:schedule:"      // Schedule JSON, by ID
+	redisScheduleFireKeyPrefix = "async:schedule_fire:" // Last fire time of a schedule in unix milliseconds, by ID
+)
+
+// Redis backed job store, it can be shared by several servers
+type redisStore struct {
+	stop chan struct{}
+	pool *redis.Pool
+}
+
+func NewRedisStore(pool *redis.Pool, retention Retention) JobStore {
+
+	s := &redisStore{
+		stop: make(chan struct{}),
+		pool: pool,
+	}
+
+	go gcLoop(s, retention, s.stop)
+
+	return s
+}
+
+func redisJobKey(jobID string) string {
+	return redisJobKeyPrefix + jobID
+}
+
+func (s *redisStore) Save(j *job.Job) error {
+
+	raw, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("SET", redisJobKey(j.ID.String()), raw)
+	conn.Send("SADD", redisJobsKey, j.ID.String())
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (s *redisStore) Get(jobID uuid.UUID) (*job.Job, error) {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	raw, err := redis.Bytes(conn.Do("GET", redisJobKey(jobID.String())))
+	if err == redis.ErrNil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job.Job{}
+	if err := json.Unmarshal(raw, j); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (s *redisStore) List() []*job.Job {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", redisJobsKey))
+	if err != nil {
+		log.Printf("store: fail to list jobs: %v", err)
+		return []*job.Job{}
+	}
+	if len(ids) == 0 {
+		return []*job.Job{}
+	}
+
+	keys := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, redisJobKey(id))
+	}
+
+	raws, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		log.Printf("store: fail to list jobs: %v", err)
+		return []*job.Job{}
+	}
+
+	jobs := make([]*job.Job, 0, len(raws))
+	for _, raw := range raws {
+		// Deleted meanwhile
+		if raw == nil {
+			continue
+		}
+
+		j := &job.Job{}
+		if err := json.Unmarshal(raw, j); err != nil {
+			log.Printf("store: invalid job: %v", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs
+}
+
+func (s *redisStore) Delete(jobID uuid.UUID) error {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", redisJobKey(jobID.String()))
+	conn.Send("SREM", redisJobsKey, jobID.String())
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func redisScheduleKey(scheduleID string) string {
+	return redisScheduleKeyPrefix + scheduleID
+}
+
+func redisScheduleFireKey(scheduleID string) string {
+	return redisScheduleFireKeyPrefix + scheduleID
+}
+
+// SaveSchedule saves sch, its last fire time is only updated by ClaimFire
+func (s *redisStore) SaveSchedule(sch *schedule.Schedule) error {
+
+	raw, err := json.Marshal(sch)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("SET", redisScheduleKey(sch.ID.String()), raw)
+	conn.Send("SADD", redisSchedulesKey, sch.ID.String())
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (s *redisStore) GetSchedule(scheduleID uuid.UUID) (*schedule.Schedule, error) {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("MGET", redisScheduleKey(scheduleID.String()), redisScheduleFireKey(scheduleID.String())))
+	if err != nil {
+		return nil, err
+	}
+	if values[0] == nil {
+		return nil, ErrScheduleNotFound
+	}
+
+	return decodeSchedule(values[0], values[1])
+}
+
+// decodeSchedule decodes a schedule and its last fire time
+func decodeSchedule(raw, fire []byte) (*schedule.Schedule, error) {
+
+	sch := &schedule.Schedule{}
+	if err := json.Unmarshal(raw, sch); err != nil {
+		return nil, err
+	}
+
+	if fire != nil {
+		ms, err := redis.Int64(fire, nil)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Unix(0, ms*int64(time.Millisecond))
+		sch.LastFireAt = &t
+	}
+
+	return sch, nil
+}
+
+func (s *redisStore) ListSchedules() []*schedule.Schedule {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", redisSchedulesKey))
+	if err != nil {
+		log.Printf("store: fail to list schedules: %v", err)
+		return []*schedule.Schedule{}
+	}
+	if len(ids) == 0 {
+		return []*schedule.Schedule{}
+	}
+
+	keys := make([]interface{}, 0, 2*len(ids))
+	for _, id := range ids {
+		keys = append(keys, redisScheduleKey(id), redisScheduleFireKey(id))
+	}
+
+	values, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		log.Printf("store: fail to list schedules: %v", err)
+		return []*schedule.Schedule{}
+	}
+
+	schedules := make([]*schedule.Schedule, 0, len(ids))
+	for i := 0; i < len(values); i += 2 {
+		// Deleted meanwhile
+		if values[i] == nil {
+			continue
+		}
+
+		sch, err := decodeSchedule(values[i], values[i+1])
+		if err != nil {
+			log.Printf("store: invalid schedule: %v", err)
+			continue
+		}
+		schedules = append(schedules, sch)
+	}
+
+	return schedules
+}
+
+func (s *redisStore) DeleteSchedule(scheduleID uuid.UUID) error {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", redisScheduleKey(scheduleID.String()), redisScheduleFireKey(scheduleID.String()))
+	conn.Send("SREM", redisSchedulesKey, scheduleID.String())
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// redisClaimFireScript sets the last fire time of a schedule if it is earlier
+// It returns -1 if the schedule doesn't exist, 0 if it already fired.
+var redisClaimFireScript = redis.NewScript(2, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+local last = tonumber(redis.call("GET", KEYS[2]) or "0")
+if last >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[1])
+return 1`)
+
+func (s *redisStore) ClaimFire(scheduleID uuid.UUID, t time.Time) (bool, error) {
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ms := t.UnixNano() / int64(time.Millisecond)
+	claimed, err := redis.Int(redisClaimFireScript.Do(conn, redisScheduleKey(scheduleID.String()), redisScheduleFireKey(scheduleID.String()), ms))
+	if err != nil {
+		return false, err
+	}
+	if claimed < 0 {
+		return false, ErrScheduleNotFound
+	}
+
+	return claimed == 1, nil
+}
+
+func (s *redisStore) Close() error {
+	close(s.stop)
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/wayt/async/server/job"
+)
+
+const gcInterval = 1 * time.Minute
+
+// Retention defines how long finished jobs are kept in a JobStore
+// Unfinished jobs, their group and child jobs, and dead letters are never collected
+type Retention struct {
+	MaxAge  time.Duration // Finished jobs older than MaxAge are removed, 0 means no limit
+	MaxJobs int           // Only the MaxJobs most recent finished jobs are kept, 0 means no limit
+}
+
+// expired returns the finished jobs exceeding the retention policy
+func (r Retention) expired(jobs []*job.Job, now time.Time) []*job.Job {
+
+	// Parents read the jobs they wait for again until they are finished
+	unfinished := make(map[string]bool)
+	for _, j := range jobs {
+		if !j.State.IsFinal() {
+			unfinished[j.ID.String()] = true
+		}
+	}
+
+	var finished []*job.Job
+	for _, j := range jobs {
+		if j.ParentID != nil && unfinished[j.ParentID.String()] {
+			continue
+		}
+		if j.State.IsFinal() && j.FinishedAt != nil && !j.DeadLetter {
+			finished = append(finished, j)
+		}
+	}
+
+	// Most recent first
+	sort.Slice(finished, func(i, k int) bool { return finished[i].FinishedAt.After(*finished[k].FinishedAt) })
+
+	var expired []*job.Job
+	for i, j := range finished {
+		if (r.MaxJobs > 0 && i >= r.MaxJobs) ||
+			(r.MaxAge > 0 && now.Sub(*j.FinishedAt) > r.MaxAge) {
+			expired = append(expired, j)
+		}
+	}
+
+	return expired
+}
+
+// collect removes expired jobs from s
+func collect(s JobStore, r Retention) {
+	for _, j := range r.expired(s.List(), time.Now()) {
+		if err := s.Delete(j.ID); err != nil {
+			log.Printf("store: fail to delete expired job [%s][%s]: %v", j.Name, j.ID, err)
+		}
+	}
+}
+
+// gcLoop periodically collects expired jobs until stop is closed
+func gcLoop(s JobStore, r Retention, stop <-chan struct{}) {
+
+	tk := time.NewTicker(gcInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+			collect(s, r)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+)
+
+// TestRetentionExpired tests only finished jobs exceeding the policy are expired, dead letters
+// and jobs of unfinished parents are kept
+func TestRetentionExpired(t *testing.T) {
+
+	now := time.Now()
+	finishedAt := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+
+	running := &job.Job{ID: uuid.NewV4(), Name: "running", State: job.StateRunning}
+	recent := &job.Job{Name: "recent", State: job.StateSucceeded, FinishedAt: finishedAt(time.Minute)}
+	older := &job.Job{Name: "older", State: job.StateFailed, FinishedAt: finishedAt(time.Hour)}
+	old := &job.Job{Name: "old", State: job.StateSucceeded, FinishedAt: finishedAt(48 * time.Hour)}
+	deadLetter := &job.Job{Name: "dead letter", State: job.StateFailed, FinishedAt: finishedAt(48 * time.Hour), DeadLetter: true}
+	child := &job.Job{Name: "child", State: job.StateSucceeded, FinishedAt: finishedAt(48 * time.Hour), ParentID: &running.ID}
+
+	jobs := []*job.Job{running, old, recent, older, deadLetter, child}
+
+	testCases := []struct {
+		Retention Retention
+		Expected  []*job.Job
+	}{
+		{
+			Retention: Retention{},
+			Expected:  nil,
+		},
+		{
+			Retention: Retention{MaxAge: 24 * time.Hour},
+			Expected:  []*job.Job{old},
+		},
+		{
+			Retention: Retention{MaxJobs: 1},
+			Expected:  []*job.Job{older, old},
+		},
+		{
+			Retention: Retention{MaxAge: 30 * time.Minute, MaxJobs: 2},
+			Expected:  []*job.Job{older, old},
+		},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, c.Retention.expired(jobs, now), c.Expected)
+	}
+}
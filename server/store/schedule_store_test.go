@@ -0,0 +1,123 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/gomodule/redigo/redis"
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/schedule"
+)
+
+// TestClaimFire tests a schedule fire time is claimed once by every store
+func TestClaimFire(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "async")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	srv, err := miniredis.Run()
+	assert.Equal(t, err, nil)
+	defer srv.Close()
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) { return redis.Dial("tcp", srv.Addr()) },
+	}
+	defer pool.Close()
+
+	fileStore, err := openFileStore(filepath.Join(dir, "async.db"))
+	assert.Equal(t, err, nil)
+
+	stores := map[string]ScheduleStore{
+		"memory": NewMemoryStore(Retention{}).(ScheduleStore),
+		"file":   fileStore,
+		"redis":  NewRedisStore(pool, Retention{}).(ScheduleStore),
+	}
+
+	fireAt := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC)
+
+	for name, s := range stores {
+		sch := &schedule.Schedule{
+			ID:        uuid.NewV4(),
+			Name:      "test",
+			Cron:      "@hourly",
+			Functions: []*function.Function{{Name: "test"}},
+		}
+		assert.Equal(t, s.SaveSchedule(sch), nil, name)
+
+		claimed, err := s.ClaimFire(sch.ID, fireAt)
+		assert.Equal(t, err, nil, name)
+		assert.Equal(t, claimed, true, name)
+
+		claimed, err = s.ClaimFire(sch.ID, fireAt)
+		assert.Equal(t, err, nil, name)
+		assert.Equal(t, claimed, false, name)
+
+		// Saving the schedule read before it fired keeps its fire time
+		paused := *sch
+		paused.Paused = true
+		assert.Equal(t, s.SaveSchedule(&paused), nil, name)
+
+		stored, err := s.GetSchedule(sch.ID)
+		assert.Equal(t, err, nil, name)
+		assert.Equal(t, stored.Paused, true, name)
+		assert.Equal(t, stored.LastFireAt.Equal(fireAt), true, name)
+		assert.Equal(t, len(s.ListSchedules()), 1, name)
+
+		claimed, err = s.ClaimFire(sch.ID, fireAt.Add(time.Hour))
+		assert.Equal(t, err, nil, name)
+		assert.Equal(t, claimed, true, name)
+
+		assert.Equal(t, s.DeleteSchedule(sch.ID), nil, name)
+
+		_, err = s.GetSchedule(sch.ID)
+		assert.Equal(t, err, ErrScheduleNotFound, name)
+
+		_, err = s.ClaimFire(sch.ID, fireAt.Add(2*time.Hour))
+		assert.Equal(t, err, ErrScheduleNotFound, name)
+
+		s.(JobStore).Close()
+	}
+}
+
+// TestFileStoreReopenSchedules tests schedules and their fire times are restored when a file store is reopened
+func TestFileStoreReopenSchedules(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "async")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "async.db")
+
+	s, err := openFileStore(path)
+	assert.Equal(t, err, nil)
+
+	sch := &schedule.Schedule{ID: uuid.NewV4(), Name: "test", Cron: "@hourly"}
+	assert.Equal(t, s.SaveSchedule(sch), nil)
+
+	fireAt := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC)
+	claimed, err := s.ClaimFire(sch.ID, fireAt)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, claimed, true)
+
+	deleted := &schedule.Schedule{ID: uuid.NewV4(), Name: "deleted", Cron: "@hourly"}
+	assert.Equal(t, s.SaveSchedule(deleted), nil)
+	assert.Equal(t, s.DeleteSchedule(deleted.ID), nil)
+	assert.Equal(t, s.Close(), nil)
+
+	s, err = openFileStore(path)
+	assert.Equal(t, err, nil)
+	defer s.Close()
+
+	assert.Equal(t, len(s.ListSchedules()), 1)
+
+	claimed, err = s.ClaimFire(sch.ID, fireAt)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, claimed, false)
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/schedule"
+)
+
+var (
+	ErrJobNotFound      = errors.New("job not found")
+	ErrScheduleNotFound = errors.New("schedule not found")
+)
+
+// JobStore keeps track of jobs, independently of their queueing
+type JobStore interface {
+	Save(*job.Job) error
+	Get(jobID uuid.UUID) (*job.Job, error)
+	List() []*job.Job
+	Delete(jobID uuid.UUID) error
+	Close() error
+}
+
+// ScheduleStore keeps job schedules, it is implemented by every JobStore
+type ScheduleStore interface {
+	SaveSchedule(*schedule.Schedule) error
+	GetSchedule(scheduleID uuid.UUID) (*schedule.Schedule, error)
+	ListSchedules() []*schedule.Schedule
+	DeleteSchedule(scheduleID uuid.UUID) error
+
+	// ClaimFire records the schedule fired at t, it returns false if it already fired at or after t
+	// Only one server claims a fire time of a shared store.
+	ClaimFire(scheduleID uuid.UUID, t time.Time) (bool, error)
+}
+
+// laterFire returns a copy of s keeping the last fire time of stored, if s fired before
+// Saving a schedule read before it fired doesn't allow it to fire again.
+func laterFire(s, stored *schedule.Schedule) *schedule.Schedule {
+
+	if stored == nil || stored.LastFireAt == nil {
+		return s
+	}
+	if s.LastFireAt != nil && !s.LastFireAt.Before(*stored.LastFireAt) {
+		return s
+	}
+
+	c := *s
+	c.LastFireAt = stored.LastFireAt
+	return &c
+}
+
+// claimFire returns a copy of stored fired at t, nil if it already fired at or after t
+func claimFire(stored *schedule.Schedule, t time.Time) *schedule.Schedule {
+
+	if stored.LastFireAt != nil && !stored.LastFireAt.Before(t) {
+		return nil
+	}
+
+	c := *stored
+	c.LastFireAt = &t
+	return &c
+}
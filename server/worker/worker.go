@@ -2,15 +2,20 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
 	"sync"
 	"time"
 
+	uuid "github.com/satori/go.uuid"
 	pb "github.com/wayt/async/pb/worker"
 	"github.com/wayt/async/server/function"
 	"github.com/wayt/async/server/job"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type workerState string
@@ -23,8 +28,9 @@ const (
 )
 
 const (
-	workerRefreshInterval = 1 * time.Second
-	maxConnectionFailure  = 3
+	workerRefreshInterval  = 1 * time.Second
+	maxConnectionFailure   = 3
+	maxUnavailableAttempts = 3 // Consecutive unavailable worker attempts not counted as retries
 )
 
 // Worker represents an async worker node
@@ -43,6 +49,9 @@ type Worker struct {
 	Address           string
 
 	client pb.WorkerClient
+
+	execsLock sync.Mutex
+	execs     map[uuid.UUID]context.CancelFunc // Running jobs executions
 }
 
 func New(address string) *Worker {
@@ -52,6 +61,7 @@ func New(address string) *Worker {
 		ConnectionFailure: 0,
 		State:             statePending,
 		Address:           address,
+		execs:             make(map[uuid.UUID]context.CancelFunc),
 	}
 }
 
@@ -218,48 +228,267 @@ func (w *Worker) Process(j *job.Job) (bool, error) {
 
 	log.Printf("worker: %s Process job: %s (%s)\n", w.ID, j.Name, j.ID)
 
-	if err := w.processFunction(j.GetCurrentFunction()); err != nil {
+	if err := j.SetState(job.StateRunning); err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.startExec(j.ID, cancel)
+	defer w.endExec(j.ID)
+
+	f := j.GetCurrentFunction()
+	if err := w.processFunction(ctx, j.ID, f, j.Data); err != nil {
 		switch err {
 		case job.ErrReschedule:
+			j.Delay(0)
+			return true, nil
+		case job.ErrRetry:
+			j.Delay(f.RetryDelay())
 			return true, nil
+		case job.ErrCancel:
+			return false, j.SetState(job.StateCancelled)
+		case job.ErrFail:
+			j.Error = functionError(j, f)
+			j.DeadLetter = true
+
+			// Dead letters are listed once compensated
+			if j.Compensate(job.StateFailed) {
+				j.Delay(0)
+				return true, nil
+			}
+			return false, j.SetState(job.StateFailed)
 		case job.ErrAbort:
-			return false, nil
+			// A failed compensation isn't compensated
+			if j.IsCompensating() {
+				j.Error = functionError(j, f)
+				return false, j.SetState(job.StateAborted)
+			}
+
+			j.Error = f.LastError()
+			if j.Compensate(job.StateAborted) {
+				j.Delay(0)
+				return true, nil
+			}
+			return false, j.SetState(job.StateAborted)
 		default:
 			return false, err
 		}
 	}
 
-	return j.IncrCurrentFunction(), nil
+	j.MergeResult(f.Result)
+
+	// Function spawned jobs to wait for, the broker moves to the next function once they're finished
+	if len(f.Children) > 0 {
+		j.Delay(0)
+		return true, nil
+	}
+
+	if !j.IncrCurrentFunction() {
+		return false, j.SetState(j.CompletedState())
+	}
+
+	j.Delay(time.Duration(j.GetCurrentFunction().Delay))
+
+	return true, nil
 }
 
-func (w *Worker) processFunction(f *function.Function) error {
+// functionError returns the error of job j after function f failed
+// The error of a compensation is kept with the abort cause
+func functionError(j *job.Job, f *function.Function) string {
+	if j.IsCompensating() {
+		return fmt.Sprintf("%s, compensation %s: %s", j.Error, f.Name, f.LastError())
+	}
+	return f.LastError()
+}
 
-	f.IncrRetryCount()
+func (w *Worker) processFunction(ctx context.Context, jobID uuid.UUID, f *function.Function, data map[string]interface{}) error {
+
+	args, err := json.Marshal(f.Args)
+	if err != nil {
+		log.Printf("worker: function [%s] invalid args: %v", f.Name, err)
+		return job.ErrAbort
+	}
+
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("worker: function [%s] invalid data: %v", f.Name, err)
+		return job.ErrAbort
+	}
+
+	// Deadline is sent to the worker, the function context expires with it
+	execCtx := ctx
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, time.Duration(f.Timeout))
+		defer cancel()
+	}
 
-	_, err := w.client.Exec(context.Background(), &pb.ExecRequest{
+	startedAt := time.Now()
+	reply, err := w.client.Exec(execCtx, &pb.ExecRequest{
 		Function: f.Name,
-		// Args:     args,
-		// Data:     nil,
+		Args:     args,
+		Data:     rawData,
+		JobId:    jobID.String(),
 	})
+
+	// Job cancelled, the function context is cancelled on the worker too
+	if ctx.Err() != nil {
+		f.AddAttempt(w.newAttempt(startedAt, status.Error(codes.Canceled, ctx.Err().Error())))
+		log.Printf("worker: function [%s] cancelled", f.Name)
+		return job.ErrCancel
+	}
+
 	w.checkConnectionErr(err)
+	f.AddAttempt(w.newAttempt(startedAt, err))
+
+	// Worker is gone, the function may not even have run: deliver it again without using a retry
+	// Errors reported by functions carry details, they are retried as usual
+	if err != nil && !hasExecError(err) && (status.Code(err) == codes.Unavailable || IsConnectionError(err)) {
+		if unavailableAttempts(f) <= maxUnavailableAttempts {
+			log.Printf("worker: function [%s] failed, worker unavailable: %v", f.Name, err)
+			return job.ErrReschedule
+		}
+		log.Printf("worker: function [%s] failed, worker unavailable %d times in a row", f.Name, maxUnavailableAttempts+1)
+	}
+
+	f.IncrRetryCount()
 
 	if err != nil {
 		log.Printf("worker: function [%s] failed: %v", f.Name, err)
 
+		if status.Code(err) == codes.DeadlineExceeded {
+			log.Printf("worker: function [%s] timed out after %s", f.Name, time.Duration(f.Timeout))
+		}
+
+		kind := execErrorKind(err)
+
+		// Function reported it will never succeed
+		if kind == pb.ExecError_PERMANENT {
+			log.Printf("worker: function [%s] failed, permanent error", f.Name)
+			return job.ErrAbort
+		}
+
+		// Function input cannot be decoded, retrying won't help
+		if kind != pb.ExecError_RETRYABLE && status.Code(err) == codes.InvalidArgument {
+			log.Printf("worker: function [%s] failed, invalid argument", f.Name)
+			return job.ErrAbort
+		}
+
 		if err := f.CanReschedule(); err != nil {
 			log.Printf("worker: function [%s] failed, cannot reschedule: %v", f.Name, err)
-			return job.ErrAbort
+			return job.ErrFail
 		} else {
-			log.Printf("worker: function [%s] failed, rescheduling", f.Name)
-			return job.ErrReschedule
+			log.Printf("worker: function [%s] failed, retrying", f.Name)
+			return job.ErrRetry
 		}
 	} else {
 		log.Printf("worker: function [%s] success", f.Name)
 	}
 
+	if result := reply.GetResult(); len(result) > 0 {
+		if err := json.Unmarshal(result, &f.Result); err != nil {
+			log.Printf("worker: function [%s] invalid result: %v", f.Name, err)
+			return job.ErrAbort
+		}
+	}
+
+	for _, id := range reply.GetChildren() {
+		childID, err := uuid.FromString(id)
+		if err != nil {
+			log.Printf("worker: function [%s] invalid child job id %s: %v", f.Name, id, err)
+			continue
+		}
+		f.Children = append(f.Children, &function.Child{JobID: childID, State: string(job.StateQueued)})
+	}
+
 	return nil
 }
 
+// Cancel cancels the execution of a job, it returns false if the job is not running on w
+func (w *Worker) Cancel(jobID uuid.UUID) bool {
+	w.execsLock.Lock()
+	defer w.execsLock.Unlock()
+
+	cancel, ok := w.execs[jobID]
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+func (w *Worker) startExec(jobID uuid.UUID, cancel context.CancelFunc) {
+	w.execsLock.Lock()
+	defer w.execsLock.Unlock()
+
+	w.execs[jobID] = cancel
+}
+
+func (w *Worker) endExec(jobID uuid.UUID) {
+	w.execsLock.Lock()
+	defer w.execsLock.Unlock()
+
+	if cancel, ok := w.execs[jobID]; ok {
+		cancel()
+		delete(w.execs, jobID)
+	}
+}
+
+// newAttempt builds an execution attempt report, err is the Exec error if any
+func (w *Worker) newAttempt(startedAt time.Time, err error) *function.Attempt {
+	finishedAt := time.Now()
+
+	a := &function.Attempt{
+		WorkerID:   w.ID,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Duration:   function.Duration(finishedAt.Sub(startedAt)),
+	}
+
+	if err != nil {
+		s := status.Convert(err)
+		a.Error = s.Message()
+		a.Code = s.Code().String()
+	}
+
+	return a
+}
+
+// execErrorKind returns the kind of error reported by a function, from Exec error details
+func execErrorKind(err error) pb.ExecError_Kind {
+	for _, detail := range status.Convert(err).Details() {
+		if e, ok := detail.(*pb.ExecError); ok {
+			return e.Kind
+		}
+	}
+
+	return pb.ExecError_UNSPECIFIED
+}
+
+// hasExecError returns true if err was reported by a function, with error details
+func hasExecError(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if _, ok := detail.(*pb.ExecError); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unavailableAttempts returns the number of the last attempts of f which failed as the worker was unavailable
+// A closing connection fails with the cancelled code.
+func unavailableAttempts(f *function.Function) int {
+	count := 0
+	for i := len(f.Attempts) - 1; i >= 0; i-- {
+		if code := f.Attempts[i].Code; code != codes.Unavailable.String() && code != codes.Canceled.String() {
+			break
+		}
+		count++
+	}
+	return count
+}
+
 // IsConnectionError returns true when err is connection problem
 func IsConnectionError(err error) bool {
 	if err == grpc.ErrClientConnClosing ||
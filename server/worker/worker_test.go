@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	uuid "github.com/satori/go.uuid"
+	pb "github.com/wayt/async/pb/worker"
+	"github.com/wayt/async/server/function"
+	"github.com/wayt/async/server/job"
+	"github.com/wayt/async/server/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// workerClient is a pb.WorkerClient failing executions of the functions in errs, others succeed
+type workerClient struct {
+	pb.WorkerClient
+	errs map[string]error
+}
+
+func (c *workerClient) Exec(ctx context.Context, in *pb.ExecRequest, opts ...grpc.CallOption) (*pb.ExecReply, error) {
+	if err := c.errs[in.Function]; err != nil {
+		return nil, err
+	}
+	return &pb.ExecReply{}, nil
+}
+
+// newTestJob returns a queued job of a single function with retryLimit retries
+func newTestJob(retryLimit int32) *job.Job {
+	return &job.Job{
+		ID:    uuid.NewV4(),
+		Name:  "test",
+		State: job.StateQueued,
+		Functions: []*function.Function{
+			{Name: "/v1/test", RetryOptions: &function.RetryOptions{RetryLimit: retryLimit}},
+		},
+	}
+}
+
+// process processes j on workers failing executions with errs until j is finished, it returns the processings count
+func process(t *testing.T, j *job.Job, errs map[string]error) int {
+
+	for count := 1; ; count++ {
+		// Workers disconnect after connection errors, the job is redelivered to another one
+		w := New("127.0.0.1:0")
+		w.client = &workerClient{errs: errs}
+
+		reschedule, err := w.Process(j)
+		assert.Equal(t, err, nil)
+
+		if !reschedule {
+			return count
+		}
+
+		// As done by brokers when the job is queued again
+		assert.Equal(t, j.SetState(job.StateQueued), nil)
+	}
+}
+
+// TestProcessFunctionUnavailable tests an unavailable function is retried as long as its retry options allow it
+func TestProcessFunctionUnavailable(t *testing.T) {
+
+	s, err := status.New(codes.Unavailable, "downstream unavailable").WithDetails(&pb.ExecError{})
+	assert.Equal(t, err, nil)
+
+	j := newTestJob(3)
+	assert.Equal(t, process(t, j, map[string]error{"/v1/test": s.Err()}), 3)
+
+	assert.Equal(t, j.State, job.StateFailed)
+	assert.Equal(t, j.DeadLetter, true)
+	assert.Equal(t, j.Functions[0].RetryCount, int32(3))
+}
+
+// TestProcessWorkerUnavailable tests executions failing on an unavailable worker are redelivered a few times before counting as retries
+func TestProcessWorkerUnavailable(t *testing.T) {
+
+	j := newTestJob(3)
+	err := status.Error(codes.Unavailable, "connection refused")
+	assert.Equal(t, process(t, j, map[string]error{"/v1/test": err}), maxUnavailableAttempts+3)
+
+	assert.Equal(t, j.State, job.StateFailed)
+	assert.Equal(t, j.DeadLetter, true)
+	assert.Equal(t, j.Functions[0].RetryCount, int32(3))
+}
+
+// TestProcessFailedCompensate tests functions which ran before a function exhausting its retries are compensated
+func TestProcessFailedCompensate(t *testing.T) {
+
+	j := &job.Job{
+		ID:    uuid.NewV4(),
+		Name:  "test",
+		State: job.StateQueued,
+		Functions: []*function.Function{
+			{Name: "/v1/reserve", Compensate: "/v1/release"},
+			{Name: "/v1/charge", RetryOptions: &function.RetryOptions{RetryLimit: 1}},
+		},
+		CurrentFunction: 1,
+	}
+
+	assert.Equal(t, process(t, j, map[string]error{"/v1/charge": errors.New("declined")}), 2)
+
+	assert.Equal(t, j.State, job.StateFailed)
+	assert.Equal(t, j.DeadLetter, true)
+	assert.Equal(t, j.Error, "declined")
+	assert.Equal(t, len(j.Compensations), 1)
+	assert.Equal(t, j.Compensations[0].Name, "/v1/release")
+	assert.Equal(t, len(j.Compensations[0].Attempts), 1)
+}
+
+// TestProcessAttempts tests executions are recorded as attempts of the function, with their timing and error,
+// and are kept when the job is saved
+func TestProcessAttempts(t *testing.T) {
+
+	st, err := status.New(codes.Unavailable, "downstream unavailable").WithDetails(&pb.ExecError{})
+	assert.Equal(t, err, nil)
+	unavailable := st.Err()
+
+	testCases := []struct {
+		RetryLimit int32
+		Errs       []error // Error of each execution
+		Expected   job.State
+	}{
+		{Errs: []error{nil}, Expected: job.StateSucceeded},
+		{RetryLimit: 2, Errs: []error{unavailable, nil}, Expected: job.StateSucceeded},
+		{RetryLimit: 2, Errs: []error{unavailable, unavailable}, Expected: job.StateFailed},
+	}
+
+	dir, err := ioutil.TempDir("", "async")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	for i, c := range testCases {
+		j := newTestJob(c.RetryLimit)
+
+		for k, execErr := range c.Errs {
+			w := New("127.0.0.1:0")
+			w.client = &workerClient{errs: map[string]error{"/v1/test": execErr}}
+
+			reschedule, err := w.Process(j)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, reschedule, k < len(c.Errs)-1)
+
+			if reschedule {
+				assert.Equal(t, j.SetState(job.StateQueued), nil)
+			}
+		}
+
+		assert.Equal(t, j.State, c.Expected)
+
+		attempts := j.Functions[0].Attempts
+		assert.Equal(t, len(attempts), len(c.Errs))
+
+		for k, a := range attempts {
+			assert.Equal(t, a.StartedAt.IsZero(), false)
+			assert.Equal(t, a.FinishedAt.Before(a.StartedAt), false)
+			assert.Equal(t, a.Duration, function.Duration(a.FinishedAt.Sub(a.StartedAt)))
+
+			if c.Errs[k] == nil {
+				assert.Equal(t, a.Error, "")
+				assert.Equal(t, a.Code, "")
+			} else {
+				assert.Equal(t, a.Error, "downstream unavailable")
+				assert.Equal(t, a.Code, codes.Unavailable.String())
+			}
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("async-%d.db", i))
+
+		s, err := store.NewFileStore(path, store.Retention{})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, s.Save(j), nil)
+		assert.Equal(t, s.Close(), nil)
+
+		s, err = store.NewFileStore(path, store.Retention{})
+		assert.Equal(t, err, nil)
+
+		saved, err := s.Get(j.ID)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(saved.Functions[0].Attempts), len(attempts))
+
+		for k, a := range saved.Functions[0].Attempts {
+			assert.Equal(t, a.WorkerID, attempts[k].WorkerID)
+			assert.Equal(t, a.StartedAt.Equal(attempts[k].StartedAt), true)
+			assert.Equal(t, a.FinishedAt.Equal(attempts[k].FinishedAt), true)
+			assert.Equal(t, a.Duration, attempts[k].Duration)
+			assert.Equal(t, a.Error, attempts[k].Error)
+			assert.Equal(t, a.Code, attempts[k].Code)
+		}
+
+		assert.Equal(t, s.Close(), nil)
+	}
+}